@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var FlagConfig = &cli.StringFlag{
+	Name:    "config",
+	Usage:   "YAML file of flag values, keyed by flag name; flags set on the command line or in the environment take precedence",
+	EnvVars: []string{"LASSIE_CONFIG"},
+}
+
+// loadConfigFile applies the values in the YAML file given by --config to
+// every flag not already set on the command line or in the environment. List
+// values are applied one element at a time, for flags that take several.
+func loadConfigFile(cctx *cli.Context) error {
+	path := cctx.String("config")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	// aliases are separate flags underneath, so values are always set on
+	// the flag's primary name, which is the one that is read
+	primaryNames := make(map[string]string)
+	sliceFlags := make(map[string]bool)
+	for _, flag := range cctx.App.Flags {
+		for _, name := range flag.Names() {
+			primaryNames[name] = flag.Names()[0]
+		}
+		if f, ok := flag.(cli.DocGenerationSliceFlag); ok && f.IsSliceFlag() {
+			sliceFlags[flag.Names()[0]] = true
+		}
+	}
+	for key, value := range values {
+		name, ok := primaryNames[key]
+		if !ok || name == "config" {
+			return fmt.Errorf("unknown flag %q in config file %s", key, path)
+		}
+		// IsSet covers both the command line and environment variables
+		if cctx.IsSet(name) {
+			continue
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		// each Set appends to a slice flag, but replaces the value of any
+		// other, so lists for those are given as a comma separated string,
+		// as on the command line
+		if !sliceFlags[name] && len(items) > 1 {
+			strs := make([]string, len(items))
+			for i, item := range items {
+				strs[i] = fmt.Sprint(item)
+			}
+			items = []interface{}{strings.Join(strs, ",")}
+		}
+		for _, item := range items {
+			if err := cctx.Set(name, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("invalid value for %s in config file %s: %w", name, path, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	type values struct {
+		Address   string
+		Port      uint
+		Verbose   bool
+		AllowIPs  []string
+		Protocols string
+	}
+	tests := []struct {
+		name    string
+		config  string
+		args    []string
+		env     map[string]string
+		want    values
+		wantErr string
+	}{
+		{
+			name:   "values from the file",
+			config: "address: 0.0.0.0\nport: 8080\nverbose: true\nallow-ips: [192.0.2.1, 10.0.0.0/8]\n",
+			want:   values{Address: "0.0.0.0", Port: 8080, Verbose: true, AllowIPs: []string{"192.0.2.1", "10.0.0.0/8"}},
+		},
+		{
+			name:   "list for a comma separated flag",
+			config: "protocols: [bitswap, graphsync, http]\n",
+			want:   values{Address: "127.0.0.1", Port: 1234, Protocols: "bitswap,graphsync,http"},
+		},
+		{
+			name:   "aliases",
+			config: "a: 0.0.0.0\n",
+			want:   values{Address: "0.0.0.0", Port: 1234},
+		},
+		{
+			name:   "command line alias wins",
+			config: "address: 0.0.0.0\n",
+			args:   []string{"-a", "192.0.2.1"},
+			want:   values{Address: "192.0.2.1", Port: 1234},
+		},
+		{
+			name:   "command line wins",
+			config: "address: 0.0.0.0\nport: 8080\n",
+			args:   []string{"--port", "9090"},
+			want:   values{Address: "0.0.0.0", Port: 9090},
+		},
+		{
+			name:   "environment wins",
+			config: "address: 0.0.0.0\nport: 8080\n",
+			env:    map[string]string{"TEST_PORT": "7070"},
+			want:   values{Address: "0.0.0.0", Port: 7070},
+		},
+		{
+			name:    "unknown flag",
+			config:  "adress: 0.0.0.0\n",
+			wantErr: `unknown flag "adress"`,
+		},
+		{
+			name:    "config can't be nested",
+			config:  "config: other.yaml\n",
+			wantErr: `unknown flag "config"`,
+		},
+		{
+			name:    "invalid value",
+			config:  "port: eighty\n",
+			wantErr: "invalid value for port",
+		},
+		{
+			name:    "invalid YAML",
+			config:  "port: [8080\n",
+			wantErr: "failed to parse config file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			var got values
+			app := &cli.App{
+				Flags: []cli.Flag{
+					FlagConfig,
+					&cli.StringFlag{Name: "address", Aliases: []string{"a"}, Value: "127.0.0.1"},
+					&cli.UintFlag{Name: "port", Value: 1234, EnvVars: []string{"TEST_PORT"}},
+					&cli.BoolFlag{Name: "verbose"},
+					&cli.StringSliceFlag{Name: "allow-ips"},
+					&cli.StringFlag{Name: "protocols"},
+				},
+				Before: loadConfigFile,
+				Action: func(cctx *cli.Context) error {
+					got = values{
+						Address:   cctx.String("address"),
+						Port:      cctx.Uint("port"),
+						Verbose:   cctx.Bool("verbose"),
+						AllowIPs:  cctx.StringSlice("allow-ips"),
+						Protocols: cctx.String("protocols"),
+					}
+					return nil
+				},
+			}
+			args := append([]string{"cassiopeia", "--config", path}, tt.args...)
+			err := app.Run(args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// droppedEventsReportInterval is how often the number of dropped events is
+// logged while events are being dropped
+const droppedEventsReportInterval = time.Minute
+
+// bufferedSubscriber decouples retrievals from an event subscriber that may
+// block, such as an event recorder whose endpoint is unreachable. Events are
+// queued in a bounded buffer and, when it is full, either the oldest queued
+// event or the new event is dropped. Publishing an event never blocks.
+type bufferedSubscriber struct {
+	events     chan types.RetrievalEvent
+	dropNewest bool
+	dropped    atomic.Uint64
+	next       types.RetrievalEventSubscriber
+
+	// the wrapped subscriber batches events and posts them in the
+	// background, logging any failure, so what can be measured here is how
+	// quickly it takes events off the buffer rather than whether they
+	// reached the endpoint
+	handedOff       prometheus.Counter
+	handoffDuration prometheus.Histogram
+}
+
+func newBufferedSubscriber(next types.RetrievalEventSubscriber, size int, dropPolicy string) (*bufferedSubscriber, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("event buffer size must be positive, got %d", size)
+	}
+	var dropNewest bool
+	switch dropPolicy {
+	case "oldest":
+	case "newest":
+		dropNewest = true
+	default:
+		return nil, fmt.Errorf("unsupported event drop policy %q, must be one of oldest or newest", dropPolicy)
+	}
+	return &bufferedSubscriber{
+		events:     make(chan types.RetrievalEvent, size),
+		dropNewest: dropNewest,
+		next:       next,
+		handedOff: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "cassiopeia",
+			Name:      "event_recorder_events_handed_off_total",
+			Help:      "Retrieval events taken from the event buffer by the event recorder for batching. Posting the batches isn't measured.",
+		}),
+		handoffDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "cassiopeia",
+			Name:      "event_recorder_handoff_seconds",
+			Help:      "Time taken by the event recorder to take a retrieval event for batching, which grows when posting batches falls behind.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 10, 6),
+		}),
+	}, nil
+}
+
+// Collectors returns the metrics tracking the handoff to the wrapped
+// subscriber, for registering with the server's metrics
+func (b *bufferedSubscriber) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		b.handedOff,
+		b.handoffDuration,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "cassiopeia",
+			Name:      "event_recorder_events_dropped_total",
+			Help:      "Retrieval events dropped because the event buffer was full.",
+		}, func() float64 { return float64(b.dropped.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "cassiopeia",
+			Name:      "event_recorder_queue_depth",
+			Help:      "Retrieval events waiting in the event buffer.",
+		}, func() float64 { return float64(len(b.events)) }),
+	}
+}
+
+// Subscriber returns the subscriber to register with lassie
+func (b *bufferedSubscriber) Subscriber() types.RetrievalEventSubscriber {
+	return b.publish
+}
+
+func (b *bufferedSubscriber) publish(event types.RetrievalEvent) {
+	for {
+		select {
+		case b.events <- event:
+			return
+		default:
+		}
+
+		if b.dropNewest {
+			b.dropped.Add(1)
+			return
+		}
+		select {
+		case <-b.events:
+			b.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// Run delivers queued events to the wrapped subscriber until the context is
+// done
+func (b *bufferedSubscriber) Run(ctx context.Context) {
+	ticker := time.NewTicker(droppedEventsReportInterval)
+	defer ticker.Stop()
+
+	var reported uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-b.events:
+			start := time.Now()
+			b.next(event)
+			b.handoffDuration.Observe(time.Since(start).Seconds())
+			b.handedOff.Inc()
+		case <-ticker.C:
+			if dropped := b.dropped.Load(); dropped > reported {
+				logger.Warnw("dropped retrieval events, event recorder is not keeping up", "dropped", dropped-reported, "total_dropped", dropped)
+				reported = dropped
+			}
+		}
+	}
+}
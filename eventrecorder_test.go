@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testEvent returns a retrieval event that can be told apart from others by
+// its time
+func testEvent(i int) types.RetrievalEvent {
+	return events.StartedFindingCandidates(time.Unix(int64(i), 0), types.RetrievalID{}, cid.Undef)
+}
+
+// collect reads the value of a counter, or the sample count of a histogram
+func collect(t *testing.T, collector prometheus.Metric) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := collector.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Histogram != nil {
+		return float64(m.Histogram.GetSampleCount())
+	}
+	return m.Counter.GetValue()
+}
+
+func TestBufferedSubscriberHandoffMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		events int
+	}{
+		{name: "no events", events: 0},
+		{name: "one event", events: 1},
+		{name: "several events", events: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan types.RetrievalEvent, tt.events)
+			b, err := newBufferedSubscriber(func(event types.RetrievalEvent) { received <- event }, 10, "oldest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.events; i++ {
+				b.Subscriber()(testEvent(i))
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				b.Run(ctx)
+			}()
+			for i := 0; i < tt.events; i++ {
+				select {
+				case <-received:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("got %d events, want %d", i, tt.events)
+				}
+			}
+			cancel()
+			<-done
+
+			if got := collect(t, b.handedOff); got != float64(tt.events) {
+				t.Errorf("got %v events handed off, want %d", got, tt.events)
+			}
+			if got := collect(t, b.handoffDuration); got != float64(tt.events) {
+				t.Errorf("got %v handoff durations, want %d", got, tt.events)
+			}
+		})
+	}
+}
+
+func TestBufferedSubscriberDropPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		dropPolicy  string
+		wantQueued  []int
+		wantDropped uint64
+	}{
+		{name: "drop oldest", dropPolicy: "oldest", wantQueued: []int{2, 3, 4}, wantDropped: 2},
+		{name: "drop newest", dropPolicy: "newest", wantQueued: []int{0, 1, 2}, wantDropped: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// nothing takes events off the buffer, as when the event
+			// recorder is stuck on an unreachable endpoint
+			b, err := newBufferedSubscriber(func(types.RetrievalEvent) {}, 3, tt.dropPolicy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 5; i++ {
+				b.Subscriber()(testEvent(i))
+			}
+
+			if got := b.dropped.Load(); got != tt.wantDropped {
+				t.Errorf("got %d events dropped, want %d", got, tt.wantDropped)
+			}
+			var queued []int
+			for len(b.events) > 0 {
+				queued = append(queued, int((<-b.events).Time().Unix()))
+			}
+			if len(queued) != len(tt.wantQueued) {
+				t.Fatalf("got events %v queued, want %v", queued, tt.wantQueued)
+			}
+			for i := range queued {
+				if queued[i] != tt.wantQueued[i] {
+					t.Fatalf("got events %v queued, want %v", queued, tt.wantQueued)
+				}
+			}
+		})
+	}
+}
+
+func TestBufferedSubscriberUnreachableRecorder(t *testing.T) {
+	tests := []struct {
+		name       string
+		dropPolicy string
+	}{
+		{name: "drop oldest", dropPolicy: "oldest"},
+		{name: "drop newest", dropPolicy: "newest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the recorder takes the first event and then never returns, as
+			// when posting to an endpoint that doesn't respond
+			taken := make(chan struct{}, 1)
+			stuck := make(chan struct{})
+			defer close(stuck)
+			b, err := newBufferedSubscriber(func(types.RetrievalEvent) {
+				taken <- struct{}{}
+				<-stuck
+			}, 4, tt.dropPolicy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go b.Run(ctx)
+			b.Subscriber()(testEvent(0))
+			<-taken
+
+			const published = 1000
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 1; i < published; i++ {
+					b.Subscriber()(testEvent(i))
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("publishing blocked on the stuck event recorder")
+			}
+
+			// one event was taken by the recorder and the buffer holds four,
+			// every other event was dropped
+			if got := b.dropped.Load(); got != published-5 {
+				t.Errorf("got %d events dropped, want %d", got, published-5)
+			}
+			if got := len(b.events); got != 4 {
+				t.Errorf("got %d events queued, want the buffer full with 4", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/net/host"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/config"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	libp2phost "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+const (
+	hostInitInitialBackoff = 500 * time.Millisecond
+	hostInitMaxBackoff     = 10 * time.Second
+)
+
+// errKeyGeneration is wrapped by the error from generating the host's
+// identity key
+var errKeyGeneration = errors.New("failed to generate libp2p identity key")
+
+// newHost and generateIdentity create the libp2p host and its identity key,
+// they are variables so tests can simulate failures
+var (
+	newHost          = host.InitHost
+	generateIdentity = func() (crypto.PrivKey, error) {
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		return priv, err
+	}
+)
+
+// initHost creates the libp2p host, retrying with backoff if it fails. The
+// final error is annotated with the likely cause and how to remedy it.
+func initHost(ctx context.Context, opts []config.Option, retries uint) (libp2phost.Host, error) {
+	backoff := hostInitInitialBackoff
+	for attempt := uint(0); ; attempt++ {
+		h, err := tryInitHost(ctx, opts)
+		if err == nil {
+			return h, nil
+		}
+		err = classifyHostInitError(err)
+		if attempt >= retries {
+			return nil, fmt.Errorf("failed to create libp2p host after %d attempts: %w", attempt+1, err)
+		}
+		logger.Warnw("failed to create libp2p host, retrying", "attempt", attempt+1, "retries", retries, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > hostInitMaxBackoff {
+			backoff = hostInitMaxBackoff
+		}
+	}
+}
+
+// classifyHostInitError wraps a host creation error with a description of
+// what went wrong and a suggested remedy, where the cause can be recognized
+func classifyHostInitError(err error) error {
+	switch {
+	case causedBy(err, syscall.EADDRINUSE):
+		return fmt.Errorf("libp2p could not bind its listen port, it is already in use; stop the other process or wait for it to exit: %w", err)
+	case causedBy(err, syscall.EADDRNOTAVAIL):
+		return fmt.Errorf("libp2p could not bind its listen address, it is not available on this machine; check the network interfaces: %w", err)
+	case causedBy(err, syscall.EACCES):
+		return fmt.Errorf("libp2p was not permitted to bind its listen port; use an unprivileged port or grant the process permission: %w", err)
+	case errors.Is(err, network.ErrResourceLimitExceeded), causedBy(err, syscall.EMFILE), causedBy(err, syscall.ENFILE):
+		return fmt.Errorf("libp2p hit a resource limit; raise the open file limit (ulimit -n) or lower --libp2p-conns-highwater: %w", err)
+	case errors.Is(err, errKeyGeneration):
+		return fmt.Errorf("libp2p could not generate its identity key; check the system's source of randomness: %w", err)
+	default:
+		return err
+	}
+}
+
+// causedBy reports whether err was caused by errno. When libp2p fails to
+// listen on any of its addresses it formats the errors into its own with %s
+// rather than wrapping them, so the errno can only be recognized by its
+// message.
+func causedBy(err error, errno syscall.Errno) bool {
+	return errors.Is(err, errno) || strings.Contains(err.Error(), errno.Error())
+}
+
+// tryInitHost creates the libp2p host with an identity key generated here,
+// rather than by libp2p, so that a failure to generate it can be told apart
+func tryInitHost(ctx context.Context, opts []config.Option) (libp2phost.Host, error) {
+	priv, err := generateIdentity()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errKeyGeneration, err)
+	}
+	return newHost(ctx, append([]config.Option{libp2p.Identity(priv)}, opts...))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	libp2phost "github.com/libp2p/go-libp2p/core/host"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestInitHostFailures(t *testing.T) {
+	errRandom := errors.New("entropy source unavailable")
+	// libp2p formats the listen errors into its own with %s, breaking the
+	// error chain
+	errBind := fmt.Errorf("failed to listen on any addresses: %s", []error{&net.OpError{Op: "listen", Net: "tcp4", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}})
+	tests := []struct {
+		name    string
+		retries uint
+		// keyErrs and hostErrs are returned by successive attempts to
+		// generate the identity key and create the host, nil once they run
+		// out
+		keyErrs   []error
+		hostErrs  []error
+		wantCalls int
+		wantFail  bool
+		// wantErr is wrapped by the error, and wantHint is in its message
+		wantErr  error
+		wantHint string
+	}{
+		{name: "created", wantCalls: 1},
+		{name: "created after a retry", retries: 1, hostErrs: []error{errBind}, wantCalls: 2},
+		{name: "port in use", hostErrs: []error{errBind}, wantCalls: 1, wantFail: true, wantHint: "already in use"},
+		{name: "key generation", keyErrs: []error{errRandom}, wantFail: true, wantErr: errKeyGeneration, wantHint: "source of randomness"},
+		{name: "key generation retried", retries: 1, keyErrs: []error{errRandom, errRandom}, wantFail: true, wantErr: errRandom, wantHint: "source of randomness"},
+		{name: "key generated on retry", retries: 1, keyErrs: []error{errRandom}, wantCalls: 1},
+		{name: "unrecognized", hostErrs: []error{errors.New("boom")}, wantCalls: 1, wantFail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyErrs, hostErrs := tt.keyErrs, tt.hostErrs
+			calls := 0
+			restoreNewHost, restoreGenerateIdentity := newHost, generateIdentity
+			t.Cleanup(func() { newHost, generateIdentity = restoreNewHost, restoreGenerateIdentity })
+			generateIdentity = func() (crypto.PrivKey, error) {
+				if len(keyErrs) > 0 {
+					err := keyErrs[0]
+					keyErrs = keyErrs[1:]
+					return nil, err
+				}
+				return nil, nil
+			}
+			newHost = func(ctx context.Context, opts []libp2p.Option, _ ...multiaddr.Multiaddr) (libp2phost.Host, error) {
+				calls++
+				if len(hostErrs) > 0 {
+					err := hostErrs[0]
+					hostErrs = hostErrs[1:]
+					return nil, err
+				}
+				return nil, nil
+			}
+
+			_, err := initHost(context.Background(), nil, tt.retries)
+			if calls != tt.wantCalls {
+				t.Errorf("host created %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantFail != (err != nil) {
+				t.Fatalf("got error %v, want error %v", err, tt.wantFail)
+			}
+			if err == nil {
+				return
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want it to wrap %v", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantHint) {
+				t.Errorf("got error %q, want it to suggest %q", err, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestInitHostPortInUse(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	opts := []libp2p.Option{libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port))}
+	h, err := initHost(context.Background(), opts, 0)
+	if err == nil {
+		h.Close()
+		t.Fatal("created a host listening on a port that is already bound")
+	}
+	if !strings.Contains(err.Error(), "already in use; stop the other process") {
+		t.Errorf("got error %q, want it to explain the port is in use", err)
+	}
+}
@@ -0,0 +1,182 @@
+package httpserver
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// formatMediaTypes maps values of the format query parameter to the media
+// type they request
+var formatMediaTypes = map[string]string{
+	"car": "application/vnd.ipld.car",
+	"raw": "application/vnd.ipld.raw",
+}
+
+// restrictAccept rejects /ipfs/ requests with 406 unless they ask for at
+// least one of the allowed media types, either through the format query
+// parameter or the Accept header. Requests that don't express a preference
+// are let through and get the default format.
+func restrictAccept(allowed []string, next http.Handler) http.Handler {
+	allowedSet := make(map[string]struct{}, len(allowed))
+	for _, mediaType := range allowed {
+		allowedSet[strings.ToLower(mediaType)] = struct{}{}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ipfsPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requested := acceptedMediaTypes(r)
+		if len(requested) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, mediaType := range requested {
+			if _, ok := allowedSet[mediaType]; ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.Error(w, "none of the requested media types are served, acceptable types: "+strings.Join(allowed, ", "), http.StatusNotAcceptable)
+	})
+}
+
+// acceptedMediaTypes returns the media types a request asks for. The format
+// query parameter takes precedence over the Accept header. Wildcards and
+// types with a zero quality are ignored.
+func acceptedMediaTypes(r *http.Request) []string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if mediaType, ok := formatMediaTypes[format]; ok {
+			return []string{mediaType}
+		}
+		return []string{format}
+	}
+
+	var mediaTypes []string
+	for _, entry := range parseAccept(r.Header.Get("Accept")) {
+		if entry.q > 0 && !strings.HasSuffix(entry.mediaType, "/*") {
+			mediaTypes = append(mediaTypes, entry.mediaType)
+		}
+	}
+	return mediaTypes
+}
+
+// negotiableMediaTypes are the response media types, in order of server
+// preference, that content negotiation chooses between
+var negotiableMediaTypes = []string{
+	"application/vnd.ipld.car",
+	"application/vnd.ipld.raw",
+}
+
+// allowedMediaTypes filters media types down to those in the allowed list,
+// or returns them all if the list is empty
+func allowedMediaTypes(mediaTypes []string, allowed []string) []string {
+	if len(allowed) == 0 {
+		return mediaTypes
+	}
+	var filtered []string
+	for _, mediaType := range mediaTypes {
+		for _, a := range allowed {
+			if strings.EqualFold(mediaType, a) {
+				filtered = append(filtered, mediaType)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// acceptEntry is a single media range from an Accept header
+type acceptEntry struct {
+	mediaType string
+	params    map[string]string
+	q         float64
+}
+
+// parseAccept parses an Accept header into its media ranges, skipping any
+// that are malformed
+func parseAccept(header string) []acceptEntry {
+	var entries []acceptEntry
+	for _, value := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+			delete(params, "q")
+		}
+		entries = append(entries, acceptEntry{mediaType: mediaType, params: params, q: q})
+	}
+	return entries
+}
+
+// negotiateAccept picks the supported media type a client prefers when its
+// Accept header lists several, honoring quality values, and rewrites the
+// header to that single choice. Requests accepting none of the supported
+// types get 406. Requests with a single Accept entry, or that select a
+// format with the format query parameter, are left as they are.
+func negotiateAccept(supported []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ipfsPathPrefix) || r.URL.Query().Has("format") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		entries := parseAccept(r.Header.Get("Accept"))
+		if len(entries) < 2 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		chosen, ok := negotiate(entries, supported)
+		if !ok {
+			http.Error(w, "none of the requested media types are supported, supported types: "+strings.Join(supported, ", "), http.StatusNotAcceptable)
+			return
+		}
+		r.Header.Set("Accept", chosen)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// negotiate returns the Accept value for the supported media type with the
+// highest quality. Each supported type takes its quality from the most
+// specific matching media range; ties go to the server's preference order.
+// If the best match is an exact media range its parameters, other than the
+// quality, are kept.
+func negotiate(entries []acceptEntry, supported []string) (string, bool) {
+	var best string
+	var bestQ float64
+	for _, mediaType := range supported {
+		value, q, specificity := mediaType, 0.0, -1
+		for _, entry := range entries {
+			var s int
+			switch {
+			case entry.mediaType == mediaType:
+				s = 2
+			case entry.mediaType == "*/*":
+				s = 0
+			case strings.HasSuffix(entry.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(entry.mediaType, "*")):
+				s = 1
+			default:
+				continue
+			}
+			if s > specificity {
+				specificity, q = s, entry.q
+				if s == 2 {
+					value = mime.FormatMediaType(mediaType, entry.params)
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = value, q
+		}
+	}
+	return best, bestQ > 0
+}
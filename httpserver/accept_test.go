@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+		wantOk bool
+	}{
+		{
+			name:   "highest quality wins",
+			accept: "application/vnd.ipld.car;q=0.5, application/vnd.ipld.raw;q=0.9",
+			want:   "application/vnd.ipld.raw",
+			wantOk: true,
+		},
+		{
+			name:   "ties go to the server's preference",
+			accept: "application/vnd.ipld.raw, application/vnd.ipld.car",
+			want:   "application/vnd.ipld.car",
+			wantOk: true,
+		},
+		{
+			name:   "parameters of an exact match are kept",
+			accept: "application/vnd.ipld.car;version=1;order=dfs;q=0.8, application/vnd.ipld.raw;q=0.1",
+			want:   "application/vnd.ipld.car; order=dfs; version=1",
+			wantOk: true,
+		},
+		{
+			name:   "most specific range sets the quality",
+			accept: "application/*;q=0.9, application/vnd.ipld.car;q=0",
+			want:   "application/vnd.ipld.raw",
+			wantOk: true,
+		},
+		{
+			name:   "wildcard",
+			accept: "text/html, */*;q=0.1",
+			want:   "application/vnd.ipld.car",
+			wantOk: true,
+		},
+		{
+			name:   "nothing supported",
+			accept: "text/html, application/json",
+			wantOk: false,
+		},
+		{
+			name:   "everything refused",
+			accept: "application/vnd.ipld.car;q=0, */*;q=0",
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := negotiate(parseAccept(tt.accept), negotiableMediaTypes)
+			if ok != tt.wantOk {
+				t.Fatalf("negotiate(%q) ok = %v, want %v", tt.accept, ok, tt.wantOk)
+			}
+			if got != tt.want {
+				t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateAccept(t *testing.T) {
+	const root = "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	tests := []struct {
+		name       string
+		target     string
+		accept     string
+		wantStatus int
+		wantAccept string
+	}{
+		{name: "single entry is left alone", target: root, accept: "text/html", wantStatus: http.StatusOK, wantAccept: "text/html"},
+		{name: "format parameter takes precedence", target: root + "?format=raw", accept: "text/html, application/json", wantStatus: http.StatusOK, wantAccept: "text/html, application/json"},
+		{name: "rewritten to the choice", target: root, accept: "application/vnd.ipld.raw;q=0.2, application/vnd.ipld.car;q=0.1", wantStatus: http.StatusOK, wantAccept: "application/vnd.ipld.raw"},
+		{name: "not acceptable", target: root, accept: "text/html, application/json", wantStatus: http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAccept string
+			handler := negotiateAccept(negotiableMediaTypes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAccept = r.Header.Get("Accept")
+			}))
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotAccept != tt.wantAccept {
+				t.Errorf("Accept %q, want %q", gotAccept, tt.wantAccept)
+			}
+		})
+	}
+}
+
+func TestRestrictAccept(t *testing.T) {
+	const root = "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	allowed := []string{"application/vnd.ipld.car"}
+	tests := []struct {
+		name       string
+		target     string
+		accept     string
+		wantStatus int
+	}{
+		{name: "allowed type", target: root, accept: "application/vnd.ipld.car", wantStatus: http.StatusOK},
+		{name: "allowed type is case insensitive", target: root, accept: "Application/Vnd.Ipld.Car", wantStatus: http.StatusOK},
+		{name: "one of several allowed", target: root, accept: "application/vnd.ipld.raw, application/vnd.ipld.car;q=0.5", wantStatus: http.StatusOK},
+		{name: "no preference", target: root, wantStatus: http.StatusOK},
+		{name: "wildcards only", target: root, accept: "*/*", wantStatus: http.StatusOK},
+		{name: "disallowed type", target: root, accept: "application/vnd.ipld.raw", wantStatus: http.StatusNotAcceptable},
+		{name: "allowed type refused", target: root, accept: "application/vnd.ipld.car;q=0, application/vnd.ipld.raw", wantStatus: http.StatusNotAcceptable},
+		{name: "allowed format parameter", target: root + "?format=car", accept: "application/vnd.ipld.raw", wantStatus: http.StatusOK},
+		{name: "disallowed format parameter", target: root + "?format=raw", accept: "application/vnd.ipld.car", wantStatus: http.StatusNotAcceptable},
+		{name: "unknown format parameter", target: root + "?format=tar", wantStatus: http.StatusNotAcceptable},
+		{name: "outside /ipfs/", target: "/health", accept: "application/vnd.ipld.raw", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := restrictAccept(allowed, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
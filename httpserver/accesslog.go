@@ -0,0 +1,81 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accessLogEntry is the record logged for each request
+type accessLogEntry struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Status   int    `json:"status"`
+	Bytes    int64  `json:"bytes"`
+	Duration string `json:"duration"`
+	Cache    string `json:"cache"`
+	// ConnBytes is the number of bytes written to the connection so far,
+	// including headers and any earlier requests on it
+	ConnBytes uint64 `json:"conn_bytes"`
+}
+
+// accessLog logs every request at INFO level, formatted as "logfmt" or
+// "json". The cache result is taken from the Cache-Status header Souin
+// leaves on the response.
+func accessLog(format string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		tw := &trackingWriter{ResponseWriter: w}
+		next.ServeHTTP(tw, r)
+
+		entry := accessLogEntry{
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Status:   tw.status,
+			Bytes:    tw.written,
+			Duration: time.Since(start).String(),
+			Cache:    cacheResult(tw.Header().Get("Cache-Status")),
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if c, ok := ConnFromContext(r.Context()); ok {
+			if cc, ok := asCountingConn(c); ok {
+				entry.ConnBytes = cc.BytesWritten()
+			}
+		}
+		if format == "json" {
+			line, err := json.Marshal(entry)
+			if err != nil {
+				logger.Warnw("failed to format access log entry", "err", err)
+				return
+			}
+			logger.Info(string(line))
+			return
+		}
+		logger.Info(entry.logfmt())
+	})
+}
+
+func (e accessLogEntry) logfmt() string {
+	return strings.Join([]string{
+		"method=" + logfmtValue(e.Method),
+		"path=" + logfmtValue(e.Path),
+		"status=" + strconv.Itoa(e.Status),
+		"bytes=" + strconv.FormatInt(e.Bytes, 10),
+		"duration=" + e.Duration,
+		"cache=" + e.Cache,
+		"conn_bytes=" + strconv.FormatUint(e.ConnBytes, 10),
+	}, " ")
+}
+
+// logfmtValue quotes a value if it would otherwise be ambiguous in logfmt
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " =\"\\") || strings.IndexFunc(v, func(r rune) bool { return r < ' ' }) >= 0 {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
@@ -0,0 +1,149 @@
+package httpserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/ipfs/go-log/v2"
+)
+
+// captureAccessLog enables INFO logging for the server and returns the
+// access log lines it writes until the test ends
+func captureAccessLog(t *testing.T) <-chan string {
+	t.Helper()
+	const subsystem = "cassiopeia/httpserver"
+	cfg := log.GetConfig()
+	level, ok := cfg.SubsystemLevels[subsystem]
+	if !ok {
+		level = cfg.Level
+	}
+	for _, name := range []string{"debug", "info", "warn", "error", "dpanic", "panic", "fatal"} {
+		if l, _ := log.LevelFromString(name); l == level {
+			t.Cleanup(func() { _ = log.SetLogLevel(subsystem, name) })
+			break
+		}
+	}
+	if err := log.SetLogLevel(subsystem, "INFO"); err != nil {
+		t.Fatal(err)
+	}
+
+	pipe := log.NewPipeReader(log.PipeFormat(log.JSONOutput), log.PipeLevel(log.LevelInfo))
+	lines := make(chan string, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(pipe)
+		for scanner.Scan() {
+			var record struct {
+				Logger string `json:"logger"`
+				Msg    string `json:"msg"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &record) != nil || record.Logger != subsystem {
+				continue
+			}
+			if strings.HasPrefix(record.Msg, "method=") || strings.HasPrefix(record.Msg, `{"method"`) {
+				lines <- record.Msg
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		pipe.Close()
+		<-done
+	})
+	return lines
+}
+
+// parseAccessLog decodes an access log line in either format
+func parseAccessLog(t *testing.T, format string, line string) accessLogEntry {
+	t.Helper()
+	var entry accessLogEntry
+	if format == "json" {
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to decode access log %q: %v", line, err)
+		}
+		return entry
+	}
+	for _, field := range strings.Fields(line) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "method":
+			entry.Method = value
+		case "path":
+			entry.Path = value
+		case "status":
+			entry.Status, _ = strconv.Atoi(value)
+		case "bytes":
+			entry.Bytes, _ = strconv.ParseInt(value, 10, 64)
+		case "duration":
+			entry.Duration = value
+		case "cache":
+			entry.Cache = value
+		case "conn_bytes":
+			entry.ConnBytes, _ = strconv.ParseUint(value, 10, 64)
+		}
+	}
+	return entry
+}
+
+func TestAccessLog(t *testing.T) {
+	// requests are a miss, a hit and a Range request that bypasses the cache
+	requests := []struct {
+		header http.Header
+		want   accessLogEntry
+	}{
+		{want: accessLogEntry{Method: http.MethodGet, Path: "/ipfs/" + testRoot, Status: http.StatusOK, Bytes: 3, Cache: cacheMiss}},
+		{want: accessLogEntry{Method: http.MethodGet, Path: "/ipfs/" + testRoot, Status: http.StatusOK, Bytes: 3, Cache: cacheHit}},
+		{header: http.Header{"Range": []string{"bytes=0-1"}}, want: accessLogEntry{Method: http.MethodGet, Path: "/ipfs/" + testRoot, Status: http.StatusOK, Bytes: 3, Cache: cacheBypass}},
+	}
+	for _, format := range []string{"logfmt", "json"} {
+		t.Run(format, func(t *testing.T) {
+			lines := captureAccessLog(t)
+			retrieval := &stubRetrieval{body: "car"}
+			srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir(), AccessLogFormat: format}, retrieval.retrieve)
+
+			for i, req := range requests {
+				get(t, srv, req.want.Method, req.want.Path, req.header)
+				var line string
+				select {
+				case line = <-lines:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("request %d wasn't logged", i+1)
+				}
+				got := parseAccessLog(t, format, line)
+				want := req.want
+				if got.Method != want.Method || got.Path != want.Path || got.Status != want.Status || got.Bytes != want.Bytes || got.Cache != want.Cache {
+					t.Errorf("request %d logged %+v, want %+v", i+1, got, want)
+				}
+				if _, err := time.ParseDuration(got.Duration); err != nil {
+					t.Errorf("request %d logged duration %q: %v", i+1, got.Duration, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLogfmtValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "/ipfs/" + testRoot, want: "/ipfs/" + testRoot},
+		{value: "", want: `""`},
+		{value: "/ipfs/a b", want: `"/ipfs/a b"`},
+		{value: "/ipfs/a=b", want: `"/ipfs/a=b"`},
+		{value: `/ipfs/"a"`, want: `"/ipfs/\"a\""`},
+		{value: "/ipfs/a\nstatus=200", want: `"/ipfs/a\nstatus=200"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := logfmtValue(tt.value); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package httpserver
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sync"
+)
+
+const (
+	// admissionFilterBits is the size of the admission filter's bitset
+	admissionFilterBits = 1 << 20 // 128 KiB
+	// admissionFilterResetAfter is how many keys are recorded before the
+	// filter is cleared, keeping its false positive rate low and letting it
+	// forget keys that were only seen long ago
+	admissionFilterResetAfter = admissionFilterBits / 16
+	admissionFilterHashes     = 3
+)
+
+// admissionFilter is a bounded sketch of recently requested cache keys,
+// used to only admit a response to the cache on the second request for its
+// key, as the doorkeeper of TinyLFU does. It may admit a key early on a
+// false positive, but never stores more than its fixed size.
+type admissionFilter struct {
+	lock  sync.Mutex
+	bits  []uint64
+	count int
+}
+
+func newAdmissionFilter() *admissionFilter {
+	return &admissionFilter{bits: make([]uint64, admissionFilterBits/64)}
+}
+
+// admit records the key and reports whether it had been seen before
+func (f *admissionFilter) admit(key string) bool {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	seen := true
+	for i := uint32(0); i < admissionFilterHashes; i++ {
+		bit := (h1 + i*h2) % admissionFilterBits
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			seen = false
+			f.bits[bit/64] |= 1 << (bit % 64)
+		}
+	}
+	if !seen {
+		if f.count++; f.count >= admissionFilterResetAfter {
+			clear(f.bits)
+			f.count = 0
+		}
+	}
+	return seen
+}
+
+// admissionKey approximates the cache key of a request, which varies on the
+// Accept header as well as the URL
+func admissionKey(r *http.Request) string {
+	return r.URL.RequestURI() + "\n" + r.Header.Get("Accept")
+}
+
+// refuseStore asks the cache not to store the response to the request, by
+// adding no-store to the request's Cache-Control. Souin still serves a
+// stored response to such a request, and unlike no-store on the response,
+// the directive never reaches the client or downstream caches.
+func refuseStore(r *http.Request) {
+	cacheControl := r.Header.Get("Cache-Control")
+	if cacheControl != "" {
+		cacheControl += ", "
+	}
+	r.Header.Set("Cache-Control", cacheControl+"no-store")
+}
@@ -0,0 +1,111 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdmissionFilter(t *testing.T) {
+	f := newAdmissionFilter()
+	if f.admit("a") {
+		t.Error("admitted a on its first request")
+	}
+	if f.admit("b") {
+		t.Error("admitted b on its first request")
+	}
+	if !f.admit("a") {
+		t.Error("didn't admit a on its second request")
+	}
+	if !f.admit("b") {
+		t.Error("didn't admit b on its second request")
+	}
+
+	// the filter forgets every key once enough new ones have been recorded
+	for i := 0; f.count > 0; i++ {
+		f.admit(fmt.Sprint("key", i))
+	}
+	if f.admit("a") {
+		t.Error("admitted a after the filter was reset")
+	}
+}
+
+func TestAdmissionKey(t *testing.T) {
+	request := func(target, accept string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		return r
+	}
+	car := "application/vnd.ipld.car"
+	tests := []struct {
+		name     string
+		a, b     *http.Request
+		wantSame bool
+	}{
+		{name: "same request", a: request("/ipfs/"+testRoot, car), b: request("/ipfs/"+testRoot, car), wantSame: true},
+		{name: "different path", a: request("/ipfs/"+testRoot, car), b: request("/ipfs/"+otherTestRoot, car)},
+		{name: "different query", a: request("/ipfs/"+testRoot+"?dag-scope=entity", car), b: request("/ipfs/"+testRoot+"?dag-scope=all", car)},
+		{name: "different accept", a: request("/ipfs/"+testRoot, car), b: request("/ipfs/"+testRoot, "application/vnd.ipld.raw")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := admissionKey(tt.a) == admissionKey(tt.b); got != tt.wantSame {
+				t.Errorf("got same key %t, want %t", got, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestRefuseStore(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		want         string
+	}{
+		{name: "no cache control", want: "no-store"},
+		{name: "existing cache control", cacheControl: "max-age=60", want: "max-age=60, no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil)
+			if tt.cacheControl != "" {
+				r.Header.Set("Cache-Control", tt.cacheControl)
+			}
+			refuseStore(r)
+			if got := r.Header.Get("Cache-Control"); got != tt.want {
+				t.Errorf("got Cache-Control %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecondHitAdmission(t *testing.T) {
+	retrieval := &stubRetrieval{body: "car"}
+	srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir(), CacheAdmission: "second-hit"}, retrieval.retrieve)
+
+	// wantRetrievals checks how many requests reached the retrieval, those
+	// served from the cache don't
+	wantRetrievals := []int64{1, 2, 2, 2}
+	for i, want := range wantRetrievals {
+		res, body := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil)
+		if res.StatusCode != http.StatusOK || body != "car" {
+			t.Fatalf("request %d got status %d with body %q, want %d with %q", i+1, res.StatusCode, body, http.StatusOK, "car")
+		}
+		if got := retrieval.calls.Load(); got != want {
+			t.Errorf("after request %d got %d retrievals, want %d", i+1, got, want)
+		}
+	}
+
+	// another representation of the same content has its own key, so isn't
+	// admitted until it too is requested twice
+	header := http.Header{"Accept": []string{"application/vnd.ipld.raw"}}
+	for i, want := range []int64{3, 4, 4} {
+		get(t, srv, http.MethodGet, "/ipfs/"+testRoot, header)
+		if got := retrieval.calls.Load(); got != want {
+			t.Errorf("after raw request %d got %d retrievals, want %d", i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package httpserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authorized reports whether the request carries the given access token as a
+// bearer token. No request is authorized when the token is empty.
+func authorized(r *http.Request, accessToken string) bool {
+	if accessToken == "" {
+		return false
+	}
+	expected := "Bearer " + accessToken
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+// requireAccessToken rejects /ipfs/ requests that don't carry the access
+// token, or the admin token, with 401. The Authorization header is removed
+// from requests it lets through, as Souin never stores responses to requests
+// that carry one. Nothing is required when the access token is empty.
+func requireAccessToken(accessToken, adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	if accessToken == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ipfsPathPrefix) {
+			next(w, r)
+			return
+		}
+		if !authorized(r, accessToken) && !authorized(r, adminToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.Header.Del("Authorization")
+		next(w, r)
+	}
+}
@@ -0,0 +1,86 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRequireAccessToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		accessToken string
+		path        string
+		token       string
+		wantStatus  int
+	}{
+		{name: "no token configured", path: "/ipfs/" + testRoot, wantStatus: http.StatusOK},
+		{name: "access token", accessToken: "access", path: "/ipfs/" + testRoot, token: "access", wantStatus: http.StatusOK},
+		{name: "admin token", accessToken: "access", path: "/ipfs/" + testRoot, token: "admin", wantStatus: http.StatusOK},
+		{name: "missing token", accessToken: "access", path: "/ipfs/" + testRoot, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", accessToken: "access", path: "/ipfs/" + testRoot, token: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "outside /ipfs/", accessToken: "access", path: "/health", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAuthorization string
+			handler := requireAccessToken(tt.accessToken, "admin", func(w http.ResponseWriter, r *http.Request) {
+				gotAuthorization = r.Header.Get("Authorization")
+			})
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate")
+			}
+			if tt.accessToken != "" && gotAuthorization != "" {
+				t.Errorf("got Authorization %q passed on, want it removed", gotAuthorization)
+			}
+		})
+	}
+}
+
+func TestAccessTokenCaching(t *testing.T) {
+	var lk sync.Mutex
+	var gotAuthorization []string
+	retrieval := &stubRetrieval{body: "car"}
+	srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir(), EmitXCache: true, AccessToken: "access"}, func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		gotAuthorization = append(gotAuthorization, r.Header.Get("Authorization"))
+		lk.Unlock()
+		retrieval.retrieve(w, r)
+	})
+
+	if res, _ := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d without a token, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	// authenticated requests are still cached
+	header := http.Header{"Authorization": []string{"Bearer access"}}
+	for i, want := range []string{cacheMiss, cacheHit} {
+		res, body := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, header)
+		if res.StatusCode != http.StatusOK || body != "car" {
+			t.Fatalf("request %d got status %d with body %q, want %d with %q", i+1, res.StatusCode, body, http.StatusOK, "car")
+		}
+		if got := res.Header.Get("X-Cache"); got != want {
+			t.Errorf("request %d got X-Cache %q, want %q", i+1, got, want)
+		}
+	}
+	if calls := retrieval.calls.Load(); calls != 1 {
+		t.Errorf("got %d retrievals, want 1", calls)
+	}
+	lk.Lock()
+	defer lk.Unlock()
+	for _, got := range gotAuthorization {
+		if got != "" {
+			t.Errorf("retrieval got Authorization %q, want it removed", got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/darkweak/souin/pkg/middleware"
+)
+
+// cacheStats counts cache hits and misses for the /cache/stats endpoint
+type cacheStats struct {
+	cacher *middleware.SouinBaseHandler
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheStatsSnapshot struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// DiskBytes is the approximate size of the badger store on disk, it is
+	// omitted for storage that isn't on local disk
+	DiskBytes *int64 `json:"disk_bytes,omitempty"`
+}
+
+func (cs *cacheStats) observe(result string) {
+	switch result {
+	case cacheHit:
+		cs.hits.Add(1)
+	case cacheMiss:
+		cs.misses.Add(1)
+	}
+}
+
+func (cs *cacheStats) snapshot() cacheStatsSnapshot {
+	snapshot := cacheStatsSnapshot{
+		Hits:   cs.hits.Load(),
+		Misses: cs.misses.Load(),
+	}
+	if cs.cacher == nil {
+		return snapshot
+	}
+	// the badger storer embeds *badger.DB, which reports its LSM tree and
+	// value log sizes
+	if sizer, ok := cs.cacher.Storer.(interface{ Size() (int64, int64) }); ok {
+		lsm, vlog := sizer.Size()
+		size := lsm + vlog
+		snapshot.DiskBytes = &size
+	}
+	return snapshot
+}
+
+func (cs *cacheStats) handler(accessToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, accessToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(cs.snapshot()); err != nil {
+			logger.Warnw("failed to write cache stats", "err", err)
+		}
+	}
+}
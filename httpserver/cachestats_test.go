@@ -0,0 +1,131 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/darkweak/souin/pkg/middleware"
+	"github.com/darkweak/souin/pkg/storage"
+)
+
+// fakeStorer keeps cache keys in memory, the embedded storage.Storer is nil
+// so calling anything it doesn't override panics
+type fakeStorer struct {
+	storage.Storer
+	keys map[string]bool
+}
+
+func newFakeStorer(keys ...string) *fakeStorer {
+	f := &fakeStorer{keys: map[string]bool{}}
+	for _, key := range keys {
+		f.keys[key] = true
+	}
+	return f
+}
+
+func (f *fakeStorer) ListKeys() []string {
+	keys := make([]string, 0, len(f.keys))
+	for key := range f.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeStorer) Delete(key string) {
+	delete(f.keys, key)
+}
+
+// sizedStorer reports LSM tree and value log sizes like the badger storer
+type sizedStorer struct {
+	*fakeStorer
+	lsm, vlog int64
+}
+
+func (s *sizedStorer) Size() (int64, int64) {
+	return s.lsm, s.vlog
+}
+
+func TestCacheStatsSnapshot(t *testing.T) {
+	tests := []struct {
+		name          string
+		storer        storage.Storer
+		wantDiskBytes *int64
+	}{
+		{name: "no storer", storer: nil},
+		{name: "storage not on disk", storer: newFakeStorer()},
+		{name: "storage on disk", storer: &sizedStorer{fakeStorer: newFakeStorer(), lsm: 100, vlog: 23}, wantDiskBytes: int64Ptr(123)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := &cacheStats{cacher: &middleware.SouinBaseHandler{Storer: tt.storer}}
+			for _, result := range []string{cacheHit, cacheHit, cacheMiss, cacheBypass} {
+				stats.observe(result)
+			}
+
+			got := stats.snapshot()
+			if got.Hits != 2 || got.Misses != 1 {
+				t.Errorf("got %d hits and %d misses, want 2 and 1", got.Hits, got.Misses)
+			}
+			switch {
+			case tt.wantDiskBytes == nil && got.DiskBytes != nil:
+				t.Errorf("got %d disk bytes, want none", *got.DiskBytes)
+			case tt.wantDiskBytes != nil && got.DiskBytes == nil:
+				t.Errorf("got no disk bytes, want %d", *tt.wantDiskBytes)
+			case tt.wantDiskBytes != nil && *got.DiskBytes != *tt.wantDiskBytes:
+				t.Errorf("got %d disk bytes, want %d", *got.DiskBytes, *tt.wantDiskBytes)
+			}
+		})
+	}
+}
+
+func TestCacheStatsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantStatus int
+	}{
+		{name: "authorized", method: http.MethodGet, token: "secret", wantStatus: http.StatusOK},
+		{name: "missing token", method: http.MethodGet, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, token: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "wrong method", method: http.MethodPost, token: "secret", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := &cacheStats{cacher: &middleware.SouinBaseHandler{Storer: &sizedStorer{fakeStorer: newFakeStorer(), lsm: 1, vlog: 2}}}
+			stats.observe(cacheHit)
+
+			req := httptest.NewRequest(tt.method, "/cache/stats", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			stats.handler("secret")(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("got Cache-Control %q, want no-store", got)
+			}
+			var got cacheStatsSnapshot
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode stats: %v", err)
+			}
+			if got.Hits != 1 || got.Misses != 0 || got.DiskBytes == nil || *got.DiskBytes != 3 {
+				t.Errorf("got %+v, want 1 hit, 0 misses and 3 disk bytes", got)
+			}
+		})
+	}
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
@@ -0,0 +1,69 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// injectLatency delays retrievals for testing how clients cope with a slow
+// server: by firstByte before the response header is written, and by
+// perBlock before each write of the body, which lassie makes once per block.
+// It wraps the retrieval itself, as the cache buffers what it writes, so
+// responses served from the cache are not delayed.
+func injectLatency(firstByte, perBlock time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(&latencyWriter{ResponseWriter: w, ctx: r.Context(), firstByte: firstByte, perBlock: perBlock}, r)
+	}
+}
+
+type latencyWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	firstByte   time.Duration
+	perBlock    time.Duration
+	wroteHeader bool
+}
+
+func (w *latencyWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		_ = w.sleep(w.firstByte)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *latencyWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if err := w.sleep(w.perBlock); err != nil {
+		return 0, err
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *latencyWriter) sleep(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (w *latencyWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *latencyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
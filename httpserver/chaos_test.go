@@ -0,0 +1,72 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInjectLatency(t *testing.T) {
+	const blocks = 3
+	tests := []struct {
+		name      string
+		firstByte time.Duration
+		perBlock  time.Duration
+		// cancelled runs the retrieval with its request already cancelled
+		cancelled bool
+		wantDelay time.Duration
+		wantBody  string
+		wantErr   error
+	}{
+		{name: "no latency", wantBody: "blockblockblock"},
+		{name: "first byte", firstByte: 50 * time.Millisecond, wantDelay: 50 * time.Millisecond, wantBody: "blockblockblock"},
+		{name: "per block", perBlock: 20 * time.Millisecond, wantDelay: blocks * 20 * time.Millisecond, wantBody: "blockblockblock"},
+		{name: "both", firstByte: 50 * time.Millisecond, perBlock: 20 * time.Millisecond, wantDelay: 50*time.Millisecond + blocks*20*time.Millisecond, wantBody: "blockblockblock"},
+		{name: "cancelled request", firstByte: time.Hour, perBlock: time.Hour, cancelled: true, wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil)
+			if tt.cancelled {
+				ctx, cancel := context.WithCancel(req.Context())
+				cancel()
+				req = req.WithContext(ctx)
+			}
+			var errs []error
+			handler := injectLatency(tt.firstByte, tt.perBlock, func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < blocks; i++ {
+					if _, err := io.WriteString(w, "block"); err != nil {
+						errs = append(errs, err)
+					}
+				}
+			})
+
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			handler(rec, req)
+			elapsed := time.Since(start)
+
+			if elapsed < tt.wantDelay {
+				t.Errorf("took %s, want at least %s", elapsed, tt.wantDelay)
+			}
+			if elapsed > tt.wantDelay+time.Second {
+				t.Errorf("took %s, want about %s", elapsed, tt.wantDelay)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("got body %q, want %q", got, tt.wantBody)
+			}
+			for _, err := range errs {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got write error %v, want %v", err, tt.wantErr)
+				}
+			}
+			if tt.wantErr != nil && len(errs) != blocks {
+				t.Errorf("got %d write errors, want %d", len(errs), blocks)
+			}
+		})
+	}
+}
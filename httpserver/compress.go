@@ -0,0 +1,145 @@
+package httpserver
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressResponses compresses /ipfs/ responses with brotli or gzip for
+// clients that accept them. It runs outside the cache, which keeps storing
+// uncompressed responses, so a client that didn't ask for compression is
+// never sent a compressed body.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ipfsPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if r.Method == http.MethodHead || encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		next.ServeHTTP(cw, r)
+		// not deferred, so that a handler that panics to abort the response
+		// doesn't have it completed with the end of the compressed stream
+		cw.Close()
+	})
+}
+
+// negotiateEncoding returns the content coding to compress a response with
+// for an Accept-Encoding header, "br" or "gzip", or "" if the client accepts
+// neither. The coding with the highest weight wins, with brotli preferred
+// when they are equal.
+func negotiateEncoding(header string) string {
+	var br, gzip, wildcard float64
+	var brListed, gzipListed, wildcardListed bool
+	for _, value := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				q = 0
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "br":
+			br, brListed = q, true
+		case "gzip", "x-gzip":
+			gzip, gzipListed = q, true
+		case "*":
+			wildcard, wildcardListed = q, true
+		}
+	}
+	if wildcardListed {
+		if !brListed {
+			br = wildcard
+		}
+		if !gzipListed {
+			gzip = wildcard
+		}
+	}
+	switch {
+	case br > 0 && br >= gzip:
+		return "br"
+	case gzip > 0:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// compressEncoder is the stream compressor of a content coding
+type compressEncoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter compresses the response body, unless the response turns
+// out to be unsuitable for compression when its header is written
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	enc         compressEncoder
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		// the compressed body differs from the one a strong ETag names
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch w.encoding {
+		case "br":
+			w.enc = brotli.NewWriter(w.ResponseWriter)
+		default:
+			w.enc = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.enc.Write(b)
+}
+
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes the end of the compressed stream
+func (w *compressWriter) Close() {
+	if w.enc != nil {
+		_ = w.enc.Close()
+	}
+}
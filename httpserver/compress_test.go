@@ -0,0 +1,167 @@
+package httpserver
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "none", header: "", want: ""},
+		{name: "identity", header: "identity", want: ""},
+		{name: "gzip", header: "gzip", want: "gzip"},
+		{name: "x-gzip", header: "x-gzip", want: "gzip"},
+		{name: "br", header: "br", want: "br"},
+		{name: "br preferred on a tie", header: "gzip, deflate, br", want: "br"},
+		{name: "gzip weighted higher", header: "br;q=0.5, gzip;q=0.8", want: "gzip"},
+		{name: "br weighted higher", header: "br;q=0.9, gzip;q=0.8", want: "br"},
+		{name: "br refused", header: "br;q=0, gzip", want: "gzip"},
+		{name: "both refused", header: "br;q=0, gzip;q=0", want: ""},
+		{name: "case insensitive", header: "GZIP", want: "gzip"},
+		{name: "spaces around weight", header: "gzip ; q=0.5", want: "gzip"},
+		{name: "invalid weight", header: "gzip;q=high", want: ""},
+		{name: "wildcard", header: "*", want: "br"},
+		{name: "wildcard with br refused", header: "*, br;q=0", want: "gzip"},
+		{name: "wildcard refused", header: "*;q=0", want: ""},
+		{name: "listed coding beats wildcard", header: "gzip;q=1, *;q=0.1", want: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateEncoding(tt.header); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	const body = "a CAR body that compresses, a CAR body that compresses"
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		acceptEncoding string
+		status         int
+		wantEncoding   string
+		wantETag       string
+	}{
+		{name: "gzip", method: http.MethodGet, path: "/ipfs/" + testRoot, acceptEncoding: "gzip", status: http.StatusOK, wantEncoding: "gzip", wantETag: `W/"etag"`},
+		{name: "br", method: http.MethodGet, path: "/ipfs/" + testRoot, acceptEncoding: "gzip, br", status: http.StatusOK, wantEncoding: "br", wantETag: `W/"etag"`},
+		{name: "not accepted", method: http.MethodGet, path: "/ipfs/" + testRoot, status: http.StatusOK, wantETag: `"etag"`},
+		{name: "HEAD", method: http.MethodHead, path: "/ipfs/" + testRoot, acceptEncoding: "gzip", status: http.StatusOK, wantETag: `"etag"`},
+		{name: "not modified", method: http.MethodGet, path: "/ipfs/" + testRoot, acceptEncoding: "br", status: http.StatusNotModified, wantETag: `"etag"`},
+		{name: "not under /ipfs/", method: http.MethodGet, path: "/health", acceptEncoding: "gzip", status: http.StatusOK, wantETag: `"etag"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"etag"`)
+				w.WriteHeader(tt.status)
+				if tt.status == http.StatusOK && r.Method != http.MethodHead {
+					_, _ = io.WriteString(w, body)
+				}
+			}))
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("got Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if got := rec.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("got ETag %q, want %q", got, tt.wantETag)
+			}
+			wantVary := strings.HasPrefix(tt.path, ipfsPathPrefix)
+			if got := rec.Header().Get("Vary") == "Accept-Encoding"; got != wantVary {
+				t.Errorf("got Vary %q, want Accept-Encoding %v", rec.Header().Get("Vary"), wantVary)
+			}
+			if tt.status != http.StatusOK || tt.method == http.MethodHead {
+				if rec.Body.Len() != 0 {
+					t.Errorf("got a %d byte body, want none", rec.Body.Len())
+				}
+				return
+			}
+			if got := decompress(t, tt.wantEncoding, rec.Body); got != body {
+				t.Errorf("got body %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestCompressResponsesAbort(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+	}{
+		{name: "gzip", acceptEncoding: "gzip"},
+		{name: "br", acceptEncoding: "br"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "partial")
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			func() {
+				defer func() {
+					if p := recover(); p != http.ErrAbortHandler {
+						t.Errorf("got panic %v, want %v", p, http.ErrAbortHandler)
+					}
+				}()
+				handler.ServeHTTP(rec, req)
+			}()
+
+			// the aborted stream must not look complete to the client
+			var err error
+			switch tt.acceptEncoding {
+			case "br":
+				_, err = io.ReadAll(brotli.NewReader(rec.Body))
+			default:
+				var gz *gzip.Reader
+				if gz, err = gzip.NewReader(rec.Body); err == nil {
+					_, err = io.ReadAll(gz)
+				}
+			}
+			if err == nil {
+				t.Error("aborted response decompressed as a complete stream")
+			}
+		})
+	}
+}
+
+// decompress returns the body decoded with the content coding
+func decompress(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = gz
+	case "br":
+		body = brotli.NewReader(body)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
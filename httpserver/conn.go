@@ -0,0 +1,243 @@
+package httpserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// countingListener wraps accepted connections in a countingConn
+type countingListener struct {
+	net.Listener
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: c}, nil
+}
+
+// countingConn counts the bytes read from and written to a connection
+type countingConn struct {
+	net.Conn
+	read    atomic.Uint64
+	written atomic.Uint64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(uint64(n))
+	return n, err
+}
+
+// BytesRead returns the number of bytes read from the connection so far
+func (c *countingConn) BytesRead() uint64 {
+	return c.read.Load()
+}
+
+// BytesWritten returns the number of bytes written to the connection so far
+func (c *countingConn) BytesWritten() uint64 {
+	return c.written.Load()
+}
+
+// logConnState logs the bytes transferred over a connection once the server
+// is done with it
+func logConnState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	cc, ok := asCountingConn(c)
+	if !ok {
+		return
+	}
+	logger.Debugw("connection finished",
+		"state", state,
+		"local_addr", cc.LocalAddr(),
+		"remote_addr", cc.RemoteAddr(),
+		"bytes_read", cc.BytesRead(),
+		"bytes_written", cc.BytesWritten(),
+	)
+}
+
+// asCountingConn returns the countingConn underneath a connection the server
+// accepted, which may be wrapped in TLS
+func asCountingConn(c net.Conn) (*countingConn, bool) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	cc, ok := c.(*countingConn)
+	return cc, ok
+}
+
+// activeConns tracks the connections that have a request in flight, so a
+// shutdown that has to force them closed can report how many were dropped
+type activeConns struct {
+	lock  sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newActiveConns() *activeConns {
+	return &activeConns{conns: make(map[net.Conn]struct{})}
+}
+
+func (a *activeConns) connState(c net.Conn, state http.ConnState) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if state == http.StateActive {
+		a.conns[c] = struct{}{}
+	} else {
+		delete(a.conns, c)
+	}
+}
+
+// Len returns the number of connections with a request in flight
+func (a *activeConns) Len() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.conns)
+}
+
+// connLimitListener closes connections from a client IP that already has the
+// maximum number of connections open. The client IP is only looked at when
+// the connection is first read from, on the connection's own goroutine, so
+// that behind a PROXY protocol upstream it is the original client's address
+// rather than the upstream's.
+type connLimitListener struct {
+	net.Listener
+	max int
+
+	lk    sync.Mutex
+	conns map[string]int
+}
+
+func newConnLimitListener(l net.Listener, max int) *connLimitListener {
+	return &connLimitListener{
+		Listener: l,
+		max:      max,
+		conns:    make(map[string]int),
+	}
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &limitedConn{Conn: c, limit: l}, nil
+}
+
+func (l *connLimitListener) acquire(ip string) bool {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	if l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *connLimitListener) release(ip string) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+var errTooManyConns = errors.New("too many open connections from client")
+
+// limitedConn takes a slot in a connLimitListener when first read from, and
+// releases it when closed
+type limitedConn struct {
+	net.Conn
+	limit *connLimitListener
+
+	admitOnce sync.Once
+	ip        string
+	admitted  bool
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) admit() bool {
+	c.admitOnce.Do(func() {
+		c.ip = remoteIP(c.Conn)
+		c.admitted = c.limit.acquire(c.ip)
+		if !c.admitted {
+			logger.Debugw("rejecting connection, too many open connections from client", "remote_addr", c.Conn.RemoteAddr(), "max", c.limit.max)
+		}
+	})
+	return c.admitted
+}
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	if !c.admit() {
+		return 0, errTooManyConns
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *limitedConn) Write(b []byte) (int, error) {
+	if !c.admit() {
+		return 0, errTooManyConns
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *limitedConn) Close() error {
+	c.closeOnce.Do(func() {
+		// a connection closed before it was read from never takes a slot
+		c.admitOnce.Do(func() {})
+		if c.admitted {
+			c.limit.release(c.ip)
+		}
+	})
+	return c.Conn.Close()
+}
+
+// remoteIP returns the IP of the connection's remote address, or the whole
+// address if it has no port
+func remoteIP(c net.Conn) string {
+	addr := c.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// removeStaleSocket removes a unix socket left behind by a server that didn't
+// shut down cleanly, so that it can be listened on again. It refuses to
+// remove a file that isn't a socket, or a socket another server is still
+// accepting connections on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("cannot listen on %s, it exists and is not a socket", path)
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return fmt.Errorf("cannot listen on %s, another server is listening on it", path)
+	}
+	logger.Infow("removing stale unix socket", "path", path)
+	return os.Remove(path)
+}
@@ -0,0 +1,318 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+// pipeListener hands out the server ends of connections made with dial
+type pipeListener struct {
+	conns chan net.Conn
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn, 16)}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return c, nil
+}
+
+func (l *pipeListener) Close() error   { return nil }
+func (l *pipeListener) Addr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+// dial connects to the listener as if from remote, returning the client end
+func (l *pipeListener) dial(remote string) net.Conn {
+	server, client := net.Pipe()
+	l.conns <- addrConn{Conn: server, remote: &net.TCPAddr{IP: net.ParseIP(remote), Port: 40000}}
+	return client
+}
+
+// addrConn overrides the remote address of a connection
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestConnLimitBehindProxyProtocol(t *testing.T) {
+	tests := []struct {
+		name string
+		// clients are the addresses sent in each connection's PROXY header,
+		// all connections come from the same upstream
+		clients []string
+		// admitted is whether each connection gets a slot
+		admitted []bool
+	}{
+		{
+			name:     "distinct clients",
+			clients:  []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+			admitted: []bool{true, true, true},
+		},
+		{
+			name:     "one client over the limit",
+			clients:  []string{"192.0.2.1", "192.0.2.1", "192.0.2.1"},
+			admitted: []bool{true, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipes := newPipeListener()
+			trusted, err := parseCIDRs([]string{"10.0.0.1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			limit := newConnLimitListener(proxyProtocolListener{Listener: pipes, trusted: trusted}, 2)
+
+			for i, client := range tt.clients {
+				conn := pipes.dial("10.0.0.1")
+				defer conn.Close()
+				header := "PROXY TCP4 " + client + " 198.51.100.1 56324 443\r\n"
+				go func() { _, _ = conn.Write([]byte(header + "GET")) }()
+
+				c, err := limit.Accept()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer c.Close()
+				buf := make([]byte, 3)
+				_, err = c.Read(buf)
+				if admitted := !errors.Is(err, errTooManyConns); admitted != tt.admitted[i] {
+					t.Fatalf("connection %d from %s: admitted %v, want %v (err %v)", i, client, admitted, tt.admitted[i], err)
+				}
+				if err == nil && string(buf) != "GET" {
+					t.Fatalf("connection %d read %q, want the bytes after the PROXY header", i, buf)
+				}
+			}
+		})
+	}
+}
+
+func TestConnLimitReleasesOnClose(t *testing.T) {
+	pipes := newPipeListener()
+	limit := newConnLimitListener(pipes, 1)
+
+	accept := func() net.Conn {
+		client := pipes.dial("192.0.2.1")
+		t.Cleanup(func() { client.Close() })
+		go func() { _, _ = client.Write([]byte("x")) }()
+		c, err := limit.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	first := accept()
+	if _, err := first.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	// closing a connection that was never read from doesn't free a slot
+	// it never took
+	unread := accept()
+	unread.Close()
+	if _, err := accept().Read(make([]byte, 1)); !errors.Is(err, errTooManyConns) {
+		t.Fatalf("second connection: got %v, want %v", err, errTooManyConns)
+	}
+	first.Close()
+	if _, err := accept().Read(make([]byte, 1)); err != nil {
+		t.Fatalf("connection after close: %v", err)
+	}
+}
+
+func TestConnFromContextCountsBytes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.Repeat("x", 1000)
+	server := &http.Server{
+		ConnContext: saveConnInCTX,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, ok := ConnFromContext(r.Context())
+			if !ok {
+				http.Error(w, "no connection in context", http.StatusInternalServerError)
+				return
+			}
+			cc, ok := asCountingConn(c)
+			if !ok {
+				http.Error(w, "connection isn't counted", http.StatusInternalServerError)
+				return
+			}
+			// report what was written by earlier requests on the connection
+			w.Header().Set("X-Conn-Bytes", strconv.FormatUint(cc.BytesWritten(), 10))
+			_, _ = io.WriteString(w, body)
+		}),
+	}
+	go func() { _ = server.Serve(countingListener{l}) }()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+	var written []uint64
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("http://" + l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, resp.StatusCode)
+		}
+		n, err := strconv.ParseUint(resp.Header.Get("X-Conn-Bytes"), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, n)
+	}
+	if written[0] != 0 {
+		t.Fatalf("first request saw %d bytes already written", written[0])
+	}
+	if written[1] < uint64(len(body)) {
+		t.Fatalf("second request saw %d bytes written, want at least the first response's %d byte body", written[1], len(body))
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	tests := []struct {
+		name string
+		// create makes whatever is at path before the server listens on it
+		create      func(t *testing.T, path string)
+		wantErr     bool
+		wantRemoved bool
+	}{
+		{name: "nothing there", create: func(*testing.T, string) {}},
+		{
+			name: "stale socket",
+			create: func(t *testing.T, path string) {
+				l, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// leave the socket file behind as a crashed server would
+				l.(*net.UnixListener).SetUnlinkOnClose(false)
+				l.Close()
+			},
+			wantRemoved: true,
+		},
+		{
+			name: "socket in use",
+			create: func(t *testing.T, path string) {
+				l, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { l.Close() })
+			},
+			wantErr: true,
+		},
+		{
+			name: "not a socket",
+			create: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "s")
+			tt.create(t, path)
+			_, statErr := os.Lstat(path)
+			existed := statErr == nil
+
+			err := removeStaleSocket(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			_, statErr = os.Lstat(path)
+			if removed := existed && errors.Is(statErr, fs.ErrNotExist); removed != tt.wantRemoved {
+				t.Errorf("got removed %t, want %t", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s")
+	retrieval := &stubRetrieval{body: "car"}
+	subscribe := func(types.RetrievalEventSubscriber) func() { return func() {} }
+	srv, err := newHttpServer(context.Background(), HttpServerConfig{Address: "unix:" + path, TempDir: t.TempDir(), DisableCache: true}, retrieval.retrieve, subscribe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Start() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	res, err := client.Get("http://unix/ipfs/" + testRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(body) != "car" {
+		t.Errorf("got status %d with body %q, want %d with %q", res.StatusCode, body, http.StatusOK, "car")
+	}
+	client.CloseIdleConnections()
+
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v checking the socket after Close, want it removed", err)
+	}
+}
+
+func TestUnixSocketRejectsIPRules(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  HttpServerConfig
+	}{
+		{name: "max conns per IP", cfg: HttpServerConfig{MaxConnsPerIP: 1}},
+		{name: "allowed IPs", cfg: HttpServerConfig{AllowIPs: []string{"127.0.0.1"}}},
+		{name: "denied IPs", cfg: HttpServerConfig{DenyIPs: []string{"10.0.0.0/8"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "s")
+			cfg := tt.cfg
+			cfg.Address = "unix:" + path
+			cfg.TempDir = t.TempDir()
+			cfg.DisableCache = true
+			subscribe := func(types.RetrievalEventSubscriber) func() { return func() {} }
+			srv, err := newHttpServer(context.Background(), cfg, (&stubRetrieval{}).retrieve, subscribe)
+			if err == nil {
+				srv.Close()
+				t.Fatal("got no error, want the IP rules rejected")
+			}
+			if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("got %v checking the socket, want none created", err)
+			}
+		})
+	}
+}
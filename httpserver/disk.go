@@ -0,0 +1,71 @@
+package httpserver
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// diskCheckInterval is how often free space on the cache filesystem is
+// checked, and diskFree how it is measured, they are variables so tests can
+// simulate a filling disk
+var (
+	diskCheckInterval = 30 * time.Second
+	diskFree          = freeDiskSpace
+)
+
+// diskMonitor periodically checks the free space on the filesystem holding
+// the cache and reports when it drops below a minimum, so that caching can be
+// paused rather than filling the disk.
+type diskMonitor struct {
+	path    string
+	minFree uint64
+	stat    func(path string) (uint64, error)
+	paused  atomic.Bool
+}
+
+func newDiskMonitor(path string, minFree uint64) *diskMonitor {
+	return &diskMonitor{
+		path:    path,
+		minFree: minFree,
+		stat:    diskFree,
+	}
+}
+
+// Paused reports whether caching is currently paused due to low disk space
+func (m *diskMonitor) Paused() bool {
+	return m.paused.Load()
+}
+
+// Run checks the free disk space every interval until the context is done
+func (m *diskMonitor) Run(ctx context.Context, interval time.Duration) {
+	m.check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *diskMonitor) check() {
+	free, err := m.stat(m.path)
+	if err != nil {
+		logger.Warnw("failed to check free disk space for cache", "path", m.path, "err", err)
+		return
+	}
+
+	paused := free < m.minFree
+	if m.paused.Swap(paused) == paused {
+		return
+	}
+	if paused {
+		logger.Warnw("pausing caching, free disk space is below the minimum", "path", m.path, "free", free, "min_free", m.minFree)
+	} else {
+		logger.Infow("resuming caching, free disk space is above the minimum", "path", m.path, "free", free, "min_free", m.minFree)
+	}
+}
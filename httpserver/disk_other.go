@@ -0,0 +1,9 @@
+//go:build !unix
+
+package httpserver
+
+import "errors"
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("checking free disk space is not supported on this platform")
+}
@@ -0,0 +1,94 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskMonitorCheck(t *testing.T) {
+	errStat := errors.New("statfs failed")
+	type stat struct {
+		free uint64
+		err  error
+	}
+	tests := []struct {
+		name string
+		// stats are the results of successive checks
+		stats []stat
+		// want is whether caching is paused after each check
+		want []bool
+	}{
+		{name: "enough space", stats: []stat{{free: 200}, {free: 100}}, want: []bool{false, false}},
+		{name: "pause below the minimum", stats: []stat{{free: 200}, {free: 99}}, want: []bool{false, true}},
+		{name: "resume above the minimum", stats: []stat{{free: 99}, {free: 100}}, want: []bool{true, false}},
+		{name: "stat error keeps caching", stats: []stat{{err: errStat}}, want: []bool{false}},
+		{name: "stat error keeps the pause", stats: []stat{{free: 0}, {err: errStat}, {free: 200}}, want: []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newDiskMonitor(t.TempDir(), 100)
+			i := 0
+			m.stat = func(string) (uint64, error) {
+				return tt.stats[i].free, tt.stats[i].err
+			}
+			for ; i < len(tt.stats); i++ {
+				m.check()
+				if got := m.Paused(); got != tt.want[i] {
+					t.Errorf("check %d got paused %t, want %t", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDiskMonitorPausesCaching(t *testing.T) {
+	var free atomic.Uint64
+	free.Store(200)
+	restoreInterval, restoreFree := diskCheckInterval, diskFree
+	t.Cleanup(func() { diskCheckInterval, diskFree = restoreInterval, restoreFree })
+	diskCheckInterval = 10 * time.Millisecond
+	diskFree = func(string) (uint64, error) { return free.Load(), nil }
+
+	retrieval := &stubRetrieval{body: "car"}
+	srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir(), EmitXCache: true, CacheMinFreeDisk: 100}, retrieval.retrieve)
+
+	waitPaused := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for srv.disk.Paused() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("caching still paused %t, want %t", !want, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// wantXCache requests root and checks the X-Cache of the response
+	wantXCache := func(root, want string) {
+		t.Helper()
+		res, body := get(t, srv, http.MethodGet, "/ipfs/"+root, nil)
+		if res.StatusCode != http.StatusOK || body != "car" {
+			t.Fatalf("got status %d with body %q, want %d with %q", res.StatusCode, body, http.StatusOK, "car")
+		}
+		if got := res.Header.Get("X-Cache"); got != want {
+			t.Errorf("%s got X-Cache %q, want %q", root, got, want)
+		}
+	}
+
+	wantXCache(testRoot, cacheMiss)
+	wantXCache(testRoot, cacheHit)
+
+	free.Store(99)
+	waitPaused(true)
+	// content cached before the pause is still served, new content isn't stored
+	wantXCache(testRoot, cacheHit)
+	wantXCache(otherTestRoot, cacheMiss)
+	wantXCache(otherTestRoot, cacheMiss)
+
+	free.Store(100)
+	waitPaused(false)
+	wantXCache(otherTestRoot, cacheMiss)
+	wantXCache(otherTestRoot, cacheHit)
+}
@@ -0,0 +1,57 @@
+package httpserver
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const carMediaType = "application/vnd.ipld.car"
+
+// duplicatesParams maps the duplicates policies that override the client to
+// the value of the dups parameter of the CAR media type they force
+var duplicatesParams = map[string]string{
+	"allow":  "y",
+	"forbid": "n",
+}
+
+// applyDuplicates overrides whether CAR responses to /ipfs/ requests include
+// duplicate blocks, by rewriting the dups parameter the client asked for.
+// A CAR requested with the format query parameter is requested through the
+// Accept header instead, so that the parameter can be set.
+func applyDuplicates(policy string, next http.Handler) http.Handler {
+	dups := duplicatesParams[policy]
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ipfsPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		if query.Get("format") == "car" {
+			query.Del("format")
+			r.URL.RawQuery = query.Encode()
+			r.Header.Set("Accept", mime.FormatMediaType(carMediaType, map[string]string{"dups": dups}))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var rewritten []string
+		var hasCar bool
+		for _, entry := range parseAccept(r.Header.Get("Accept")) {
+			if entry.mediaType == carMediaType {
+				entry.params["dups"] = dups
+				hasCar = true
+			}
+			if entry.q < 1 {
+				entry.params["q"] = strconv.FormatFloat(entry.q, 'f', -1, 64)
+			}
+			rewritten = append(rewritten, mime.FormatMediaType(entry.mediaType, entry.params))
+		}
+		if hasCar {
+			r.Header.Set("Accept", strings.Join(rewritten, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,75 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestApplyDuplicates(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		target     string
+		accept     string
+		wantAccept string
+		wantQuery  string
+	}{
+		{name: "allow overrides the client", policy: "allow", target: "/ipfs/" + testRoot, accept: "application/vnd.ipld.car; dups=n", wantAccept: "application/vnd.ipld.car; dups=y"},
+		{name: "forbid overrides the client", policy: "forbid", target: "/ipfs/" + testRoot, accept: "application/vnd.ipld.car; dups=y", wantAccept: "application/vnd.ipld.car; dups=n"},
+		{name: "forbid adds the parameter", policy: "forbid", target: "/ipfs/" + testRoot, accept: "application/vnd.ipld.car; version=1", wantAccept: "application/vnd.ipld.car; dups=n; version=1"},
+		{name: "other entries keep their quality", policy: "allow", target: "/ipfs/" + testRoot, accept: "application/vnd.ipld.raw;q=0.5, application/vnd.ipld.car", wantAccept: "application/vnd.ipld.raw; q=0.5, application/vnd.ipld.car; dups=y"},
+		{name: "format parameter", policy: "forbid", target: "/ipfs/" + testRoot + "?format=car&dag-scope=all", wantAccept: "application/vnd.ipld.car; dups=n", wantQuery: "dag-scope=all"},
+		{name: "raw left alone", policy: "forbid", target: "/ipfs/" + testRoot, accept: "application/vnd.ipld.raw", wantAccept: "application/vnd.ipld.raw"},
+		{name: "outside /ipfs/", policy: "forbid", target: "/health", accept: "application/vnd.ipld.car; dups=y", wantAccept: "application/vnd.ipld.car; dups=y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAccept, gotQuery string
+			handler := applyDuplicates(tt.policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAccept, gotQuery = r.Header.Get("Accept"), r.URL.RawQuery
+			}))
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if gotAccept != tt.wantAccept {
+				t.Errorf("got Accept %q, want %q", gotAccept, tt.wantAccept)
+			}
+			if gotQuery != tt.wantQuery {
+				t.Errorf("got query %q, want %q", gotQuery, tt.wantQuery)
+			}
+		})
+	}
+}
+
+func TestDuplicatesPolicy(t *testing.T) {
+	tests := []struct {
+		policy     string
+		wantAccept string
+	}{
+		{policy: "", wantAccept: "application/vnd.ipld.car; dups=y"},
+		{policy: "client", wantAccept: "application/vnd.ipld.car; dups=y"},
+		{policy: "allow", wantAccept: "application/vnd.ipld.car; dups=y"},
+		{policy: "forbid", wantAccept: "application/vnd.ipld.car; dups=n"},
+	}
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			var lk sync.Mutex
+			var gotAccept string
+			srv := startTestServer(t, HttpServerConfig{DisableCache: true, Duplicates: tt.policy}, func(w http.ResponseWriter, r *http.Request) {
+				lk.Lock()
+				defer lk.Unlock()
+				gotAccept = r.Header.Get("Accept")
+			})
+			get(t, srv, http.MethodGet, "/ipfs/"+testRoot, http.Header{"Accept": []string{"application/vnd.ipld.car; dups=y"}})
+			lk.Lock()
+			defer lk.Unlock()
+			if gotAccept != tt.wantAccept {
+				t.Errorf("got Accept %q, want %q", gotAccept, tt.wantAccept)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/darkweak/souin/pkg/middleware"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// valueLogGCDiscardRatio is the fraction of a value log file that must be
+// stale for badger to rewrite it
+const valueLogGCDiscardRatio = 0.5
+
+// valueLogGCer is implemented by Souin's badger storer, which embeds a
+// badger v3 *badger.DB
+type valueLogGCer interface {
+	RunValueLogGC(discardRatio float64) error
+}
+
+// valueLogGCers returns the cache storage if it has a value log to collect
+func valueLogGCers(cacher *middleware.SouinBaseHandler) []valueLogGCer {
+	if db, ok := cacher.Storer.(valueLogGCer); ok {
+		return []valueLogGCer{db}
+	}
+	return nil
+}
+
+// runValueLogGC garbage collects the value logs of dbs every interval until
+// the context is done. Without it, the space taken by expired and
+// overwritten entries is never reclaimed.
+func runValueLogGC(ctx context.Context, dbs []valueLogGCer, interval time.Duration) {
+	if len(dbs) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, db := range dbs {
+			// each successful run rewrites a single file, so keep going
+			// until there is nothing left worth rewriting
+			var rewritten int
+			var err error
+			for ctx.Err() == nil {
+				if err = db.RunValueLogGC(valueLogGCDiscardRatio); err != nil {
+					break
+				}
+				rewritten++
+			}
+			if err != nil && !errors.Is(err, badger.ErrNoRewrite) {
+				logger.Warnw("cache value log GC failed", "rewritten", rewritten, "err", err)
+				continue
+			}
+			logger.Debugw("cache value log GC complete", "rewritten", rewritten)
+		}
+	}
+}
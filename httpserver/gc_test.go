@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// fakeValueLog counts value log GC runs, returning err from each of them
+type fakeValueLog struct {
+	runs atomic.Int64
+	err  error
+}
+
+func (f *fakeValueLog) RunValueLogGC(float64) error {
+	f.runs.Add(1)
+	return f.err
+}
+
+func TestRunValueLogGCStopsOnCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		// err is returned by every GC run, nil keeps the inner loop
+		// rewriting until the context is done
+		err error
+	}{
+		{name: "nothing to rewrite", err: badger.ErrNoRewrite},
+		{name: "always rewriting", err: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeValueLog{err: tt.err}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				runValueLogGC(ctx, []valueLogGCer{db}, time.Millisecond)
+			}()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for db.runs.Load() == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("value log GC never ran")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("value log GC didn't stop after the context was canceled")
+			}
+			runs := db.runs.Load()
+			time.Sleep(10 * time.Millisecond)
+			if db.runs.Load() != runs {
+				t.Fatal("value log GC ran after it stopped")
+			}
+		})
+	}
+}
+
+func TestRunValueLogGCWithoutBadger(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runValueLogGC(context.Background(), nil, time.Millisecond)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("value log GC didn't return without any badger storage")
+	}
+}
@@ -0,0 +1,44 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		method     string
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+	}{
+		{method: http.MethodGet, wantStatus: http.StatusOK, wantBody: `{"status":"ok"}` + "\n"},
+		{method: http.MethodHead, wantStatus: http.StatusOK},
+		{method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed, wantBody: "method not allowed\n", wantAllow: "GET, HEAD"},
+		{method: http.MethodDelete, wantStatus: http.StatusMethodNotAllowed, wantBody: "method not allowed\n", wantAllow: "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			healthHandler(rec, httptest.NewRequest(tt.method, "/health", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("got body %q, want %q", got, tt.wantBody)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("got Allow %q, want %q", got, tt.wantAllow)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+					t.Errorf("got Cache-Control %q, want %q", got, "no-store")
+				}
+				if got := rec.Header().Get("Content-Type"); got != "application/json" {
+					t.Errorf("got Content-Type %q, want %q", got, "application/json")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// defaultHTTP10MaxBufferBytes bounds how much of a response is buffered for
+// an HTTP/1.0 client before giving up on it, unless configured otherwise
+const defaultHTTP10MaxBufferBytes = 64 << 20 // 64 MiB
+
+var errHTTP10ResponseTooLarge = errors.New("response too large to buffer for HTTP/1.0")
+
+// handleHTTP10 deals with HTTP/1.0 clients, which can't receive a chunked
+// response of unknown length. By default net/http streams to them and closes
+// the connection to end the response, so this is only used for the opt-in
+// modes. In "reject" mode they get a 505. In "buffer" mode the response is
+// buffered so it can be sent with a Content-Length, falling back to a 505 if
+// it grows beyond maxBufferBytes.
+func handleHTTP10(mode string, maxBufferBytes int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoAtLeast(1, 1) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if mode == "reject" {
+			http.Error(w, "HTTP/1.0 is not supported, use HTTP/1.1 or later", http.StatusHTTPVersionNotSupported)
+			return
+		}
+
+		bw := &http10Writer{header: make(http.Header), limit: maxBufferBytes}
+		next.ServeHTTP(bw, r)
+		if bw.overflow {
+			logger.Debugw("rejecting HTTP/1.0 request, response too large to buffer", "path", r.URL.Path, "limit", maxBufferBytes)
+			http.Error(w, errHTTP10ResponseTooLarge.Error()+", use HTTP/1.1 or later", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		for k, v := range bw.header {
+			w.Header()[k] = v
+		}
+		w.Header().Del("Transfer-Encoding")
+		if r.Method != http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(bw.buf.Len()))
+		}
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+		w.WriteHeader(bw.status)
+		_, _ = bw.buf.WriteTo(w)
+	})
+}
+
+// http10Writer buffers a complete response, up to limit bytes
+type http10Writer struct {
+	header   http.Header
+	limit    int
+	status   int
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (w *http10Writer) Header() http.Header {
+	return w.header
+}
+
+func (w *http10Writer) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *http10Writer) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.overflow || w.buf.Len()+len(b) > w.limit {
+		w.overflow = true
+		return 0, errHTTP10ResponseTooLarge
+	}
+	return w.buf.Write(b)
+}
+
+// Flush is a no-op, the response is only sent once it is complete
+func (w *http10Writer) Flush() {}
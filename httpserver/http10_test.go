@@ -0,0 +1,110 @@
+package httpserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleHTTP10(t *testing.T) {
+	tests := []struct {
+		name              string
+		mode              string
+		maxBufferBytes    int
+		method            string
+		http11            bool
+		wantStatus        int
+		wantContentLength string
+		wantBody          string
+	}{
+		{name: "HTTP/1.1 passes through", mode: "reject", http11: true, wantStatus: http.StatusNotFound, wantBody: "car"},
+		{name: "rejected", mode: "reject", wantStatus: http.StatusHTTPVersionNotSupported},
+		{name: "buffered", mode: "buffer", maxBufferBytes: 3, wantStatus: http.StatusNotFound, wantContentLength: "3", wantBody: "car"},
+		{name: "buffered HEAD has no length", mode: "buffer", maxBufferBytes: 3, method: http.MethodHead, wantStatus: http.StatusNotFound},
+		{name: "too large to buffer", mode: "buffer", maxBufferBytes: 2, wantStatus: http.StatusHTTPVersionNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := handleHTTP10(tt.mode, tt.maxBufferBytes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = io.WriteString(w, "car")
+			}))
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/ipfs/"+testRoot, nil)
+			if !tt.http11 {
+				req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.0", 1, 0
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Length"); got != tt.wantContentLength {
+				t.Errorf("got Content-Length %q, want %q", got, tt.wantContentLength)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("got body %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHTTP10Server(t *testing.T) {
+	tests := []struct {
+		name              string
+		mode              string
+		maxBufferBytes    int
+		wantStatus        int
+		wantContentLength int64
+		wantBody          string
+	}{
+		{name: "passthrough", wantStatus: http.StatusOK, wantBody: "car"},
+		{name: "buffered", mode: "buffer", wantStatus: http.StatusOK, wantContentLength: 3, wantBody: "car"},
+		{name: "too large to buffer", mode: "buffer", maxBufferBytes: 2, wantStatus: http.StatusHTTPVersionNotSupported},
+		{name: "rejected", mode: "reject", wantStatus: http.StatusHTTPVersionNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retrieval := &stubRetrieval{body: "car"}
+			srv := startTestServer(t, HttpServerConfig{DisableCache: true, HTTP10Mode: tt.mode, HTTP10MaxBufferBytes: tt.maxBufferBytes}, retrieval.retrieve)
+
+			conn, err := net.Dial("tcp", srv.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := fmt.Fprintf(conn, "GET /ipfs/%s HTTP/1.0\r\nHost: %s\r\n\r\n", testRoot, srv.Addr()); err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+			if tt.wantContentLength != 0 && res.ContentLength != tt.wantContentLength {
+				t.Errorf("got Content-Length %d, want %d", res.ContentLength, tt.wantContentLength)
+			}
+			if len(res.TransferEncoding) > 0 {
+				t.Errorf("got Transfer-Encoding %v, HTTP/1.0 clients can't decode it", res.TransferEncoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package httpserver
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// filterIPs rejects requests with 403 when the client IP is in the deny
+// list, or when an allow list is given and the IP is not in it. The client IP
+// is the peer's address, unless the peer is a trusted proxy, in which case it
+// is the rightmost X-Forwarded-For address not belonging to a trusted proxy.
+func filterIPs(allow, deny, trustedProxies []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, trustedProxies)
+		if containsIP(deny, ip) || (len(allow) > 0 && !containsIP(allow, ip)) {
+			logger.Debugw("rejecting request from client IP", "ip", ip, "remote_addr", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP of the client that made a request, as described by
+// filterIPs, or nil if it can't be determined
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if !containsIP(trustedProxies, ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		value := strings.TrimSpace(forwarded[i])
+		if value == "" {
+			continue
+		}
+		forwardedIP := net.ParseIP(value)
+		if forwardedIP == nil {
+			// a malformed entry can't be trusted, nor anything before it
+			return nil
+		}
+		ip = forwardedIP
+		if !containsIP(trustedProxies, ip) {
+			return ip
+		}
+	}
+	return ip
+}
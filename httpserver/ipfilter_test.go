@@ -0,0 +1,117 @@
+package httpserver
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", values: nil, want: nil},
+		{name: "CIDRs", values: []string{"10.0.0.0/8", "2001:db8::/32"}, want: []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{name: "bare IPs", values: []string{"192.0.2.1", "2001:db8::1"}, want: []string{"192.0.2.1/32", "2001:db8::1/128"}},
+		{name: "CIDR is masked", values: []string{"10.1.2.3/8"}, want: []string{"10.0.0.0/8"}},
+		{name: "blanks are skipped", values: []string{" 192.0.2.1 ", ""}, want: []string{"192.0.2.1/32"}},
+		{name: "invalid IP", values: []string{"192.0.2"}, wantErr: true},
+		{name: "invalid CIDR", values: []string{"10.0.0.0/33"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nets, err := parseCIDRs(tt.values)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", nets)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nets) != len(tt.want) {
+				t.Fatalf("got %v, want %v", nets, tt.want)
+			}
+			for i, n := range nets {
+				if n.String() != tt.want[i] {
+					t.Errorf("network %d is %s, want %s", i, n, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		xForwardedFor []string
+		want          string
+	}{
+		{name: "direct client", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "untrusted peer can't forward", remoteAddr: "192.0.2.1:1234", xForwardedFor: []string{"198.51.100.1"}, want: "192.0.2.1"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", xForwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "rightmost untrusted entry", remoteAddr: "10.0.0.1:1234", xForwardedFor: []string{"203.0.113.9, 198.51.100.1, 10.0.0.2"}, want: "198.51.100.1"},
+		{name: "multiple headers", remoteAddr: "10.0.0.1:1234", xForwardedFor: []string{"203.0.113.9", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "only trusted proxies", remoteAddr: "10.0.0.1:1234", xForwardedFor: []string{"10.0.0.3"}, want: "10.0.0.3"},
+		{name: "malformed entry", remoteAddr: "10.0.0.1:1234", xForwardedFor: []string{"198.51.100.1, bogus"}, want: "<nil>"},
+		{name: "no port", remoteAddr: "192.0.2.1", want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.xForwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r, trusted).String(); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterIPs(t *testing.T) {
+	mustParse := func(values ...string) []*net.IPNet {
+		nets, err := parseCIDRs(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nets
+	}
+	tests := []struct {
+		name       string
+		allow      []*net.IPNet
+		deny       []*net.IPNet
+		remoteAddr string
+		wantStatus int
+	}{
+		{name: "denied", deny: mustParse("192.0.2.0/24"), remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusForbidden},
+		{name: "not denied", deny: mustParse("192.0.2.0/24"), remoteAddr: "198.51.100.1:1234", wantStatus: http.StatusOK},
+		{name: "allowed", allow: mustParse("192.0.2.0/24"), remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusOK},
+		{name: "not allowed", allow: mustParse("192.0.2.0/24"), remoteAddr: "198.51.100.1:1234", wantStatus: http.StatusForbidden},
+		{name: "deny wins over allow", allow: mustParse("192.0.2.0/24"), deny: mustParse("192.0.2.1"), remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusForbidden},
+		{name: "unknown client with an allow list", allow: mustParse("192.0.2.0/24"), remoteAddr: "@", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := filterIPs(tt.allow, tt.deny, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
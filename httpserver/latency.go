@@ -0,0 +1,206 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// latencyBuckets is the number of buckets each provider's window is
+	// split into, samples age out a bucket at a time
+	latencyBuckets = 8
+	// latencyReservoirSize bounds the number of time-to-first-byte samples
+	// kept per provider within a window
+	latencyReservoirSize = 1024
+)
+
+// providerLatencies tracks time-to-first-byte per provider from lassie's
+// retrieval events over a sliding window. The window is split into
+// time buckets, each with a fixed-size reservoir of samples, and the oldest
+// bucket is dropped once it falls out of the window, so percentiles change
+// gradually rather than resetting.
+type providerLatencies struct {
+	window    time.Duration
+	lk        sync.Mutex
+	providers map[peer.ID]*latencyReservoir
+}
+
+// latencyReservoir holds a provider's buckets, oldest first
+type latencyReservoir struct {
+	buckets []*latencyBucket
+}
+
+type latencyBucket struct {
+	start   time.Time
+	seen    int
+	samples []time.Duration
+}
+
+type providerLatency struct {
+	Samples int   `json:"samples"`
+	P50     int64 `json:"p50Ms"`
+	P95     int64 `json:"p95Ms"`
+	P99     int64 `json:"p99Ms"`
+}
+
+// weightedLatency is a sample standing in for weight samples, the number its
+// bucket saw for each one it kept
+type weightedLatency struct {
+	ttfb   time.Duration
+	weight float64
+}
+
+func newProviderLatencies(window time.Duration) *providerLatencies {
+	return &providerLatencies{
+		window:    window,
+		providers: make(map[peer.ID]*latencyReservoir),
+	}
+}
+
+// subscriber returns a retrieval event subscriber that records the duration
+// of first-byte events
+func (pl *providerLatencies) subscriber() types.RetrievalEventSubscriber {
+	return func(event types.RetrievalEvent) {
+		if event.Code() != types.FirstByteCode {
+			return
+		}
+		providerEvent, ok := event.(interface{ ProviderId() peer.ID })
+		if !ok {
+			return
+		}
+		durationEvent, ok := event.(interface{ Duration() time.Duration })
+		if !ok {
+			return
+		}
+		pl.record(providerEvent.ProviderId(), durationEvent.Duration(), event.Time())
+	}
+}
+
+func (pl *providerLatencies) bucketWidth() time.Duration {
+	return pl.window / latencyBuckets
+}
+
+func (pl *providerLatencies) record(provider peer.ID, ttfb time.Duration, at time.Time) {
+	pl.lk.Lock()
+	defer pl.lk.Unlock()
+
+	reservoir, ok := pl.providers[provider]
+	if !ok {
+		reservoir = &latencyReservoir{}
+		pl.providers[provider] = reservoir
+	}
+	pl.expire(reservoir, at)
+
+	// events may arrive slightly out of order, so anything earlier than the
+	// newest bucket goes in it
+	width := pl.bucketWidth()
+	n := len(reservoir.buckets)
+	if n == 0 || at.Sub(reservoir.buckets[n-1].start) >= width {
+		reservoir.buckets = append(reservoir.buckets, &latencyBucket{start: at.Truncate(width)})
+		n++
+	}
+	bucket := reservoir.buckets[n-1]
+
+	bucket.seen++
+	if len(bucket.samples) < latencyReservoirSize/latencyBuckets {
+		bucket.samples = append(bucket.samples, ttfb)
+		return
+	}
+	if i := rand.Intn(bucket.seen); i < len(bucket.samples) {
+		bucket.samples[i] = ttfb
+	}
+}
+
+// expire drops the buckets that have fallen entirely out of the window
+// ending at now
+func (pl *providerLatencies) expire(reservoir *latencyReservoir, now time.Time) {
+	width := pl.bucketWidth()
+	expired := 0
+	for _, bucket := range reservoir.buckets {
+		if now.Sub(bucket.start.Add(width)) < pl.window {
+			break
+		}
+		expired++
+	}
+	reservoir.buckets = reservoir.buckets[expired:]
+}
+
+// snapshot returns latency percentiles for every provider with samples in
+// the window ending at now, dropping providers without any
+func (pl *providerLatencies) snapshot(now time.Time) map[string]providerLatency {
+	pl.lk.Lock()
+	defer pl.lk.Unlock()
+
+	result := make(map[string]providerLatency, len(pl.providers))
+	for provider, reservoir := range pl.providers {
+		pl.expire(reservoir, now)
+		if len(reservoir.buckets) == 0 {
+			delete(pl.providers, provider)
+			continue
+		}
+		var seen int
+		var samples []weightedLatency
+		for _, bucket := range reservoir.buckets {
+			seen += bucket.seen
+			weight := float64(bucket.seen) / float64(len(bucket.samples))
+			for _, ttfb := range bucket.samples {
+				samples = append(samples, weightedLatency{ttfb: ttfb, weight: weight})
+			}
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i].ttfb < samples[j].ttfb })
+		result[provider.String()] = providerLatency{
+			Samples: seen,
+			P50:     percentile(samples, 50).Milliseconds(),
+			P95:     percentile(samples, 95).Milliseconds(),
+			P99:     percentile(samples, 99).Milliseconds(),
+		}
+	}
+	return result
+}
+
+// percentile returns the nearest-rank percentile p of the sorted samples,
+// counting each sample by its weight
+func percentile(sorted []weightedLatency, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	var total float64
+	for _, sample := range sorted {
+		total += sample.weight
+	}
+	rank := total * float64(p) / 100
+	var cumulative float64
+	for _, sample := range sorted {
+		cumulative += sample.weight
+		if cumulative >= rank {
+			return sample.ttfb
+		}
+	}
+	return sorted[len(sorted)-1].ttfb
+}
+
+func (pl *providerLatencies) handler(adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, adminToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(pl.snapshot(time.Now())); err != nil {
+			logger.Warnw("failed to write provider latencies", "err", err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package httpserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestProviderLatencyPercentiles(t *testing.T) {
+	tests := []struct {
+		name string
+		// samples are recorded in order, spread evenly over the window
+		samples []time.Duration
+		want    providerLatency
+	}{
+		{
+			name:    "one sample",
+			samples: []time.Duration{7 * time.Millisecond},
+			want:    providerLatency{Samples: 1, P50: 7, P95: 7, P99: 7},
+		},
+		{
+			name:    "one to a hundred",
+			samples: millisecondsUpTo(100),
+			want:    providerLatency{Samples: 100, P50: 50, P95: 95, P99: 99},
+		},
+		{
+			name:    "one to a thousand",
+			samples: millisecondsUpTo(1000),
+			want:    providerLatency{Samples: 1000, P50: 500, P95: 950, P99: 990},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := time.Hour
+			pl := newProviderLatencies(window)
+			start := time.Unix(0, 0)
+			provider := peer.ID("provider")
+			for i, ttfb := range tt.samples {
+				pl.record(provider, ttfb, start.Add(time.Duration(i)*window/time.Duration(len(tt.samples))))
+			}
+
+			got := pl.snapshot(start.Add(window))[provider.String()]
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderLatencyAging(t *testing.T) {
+	window := 8 * time.Minute
+	start := time.Unix(0, 0)
+	provider := peer.ID("provider")
+	pl := newProviderLatencies(window)
+	// a slow minute is followed by seven fast ones
+	for minute := 0; minute < 8; minute++ {
+		ttfb := 10 * time.Millisecond
+		if minute == 0 {
+			ttfb = time.Second
+		}
+		for i := 0; i < 10; i++ {
+			pl.record(provider, ttfb, start.Add(time.Duration(minute)*time.Minute+time.Duration(i)*time.Second))
+		}
+	}
+
+	tests := []struct {
+		name string
+		at   time.Duration
+		want providerLatency
+	}{
+		{name: "whole window", at: 8*time.Minute - time.Second, want: providerLatency{Samples: 80, P50: 10, P95: 1000, P99: 1000}},
+		{name: "slow minute aged out", at: 9 * time.Minute, want: providerLatency{Samples: 70, P50: 10, P95: 10, P99: 10}},
+		{name: "half aged out", at: 12 * time.Minute, want: providerLatency{Samples: 40, P50: 10, P95: 10, P99: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pl.snapshot(start.Add(tt.at))[provider.String()]
+			if !ok {
+				t.Fatal("provider has no latencies")
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if got := pl.snapshot(start.Add(16 * time.Minute)); len(got) != 0 {
+		t.Errorf("got %v after every sample aged out, want no providers", got)
+	}
+}
+
+// millisecondsUpTo returns the durations of 1ms to n ms
+func millisecondsUpTo(n int) []time.Duration {
+	samples := make([]time.Duration, n)
+	for i := range samples {
+		samples[i] = time.Duration(i+1) * time.Millisecond
+	}
+	return samples
+}
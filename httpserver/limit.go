@@ -0,0 +1,105 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// semaphore is a counting semaphore that is acquired without waiting
+type semaphore chan struct{}
+
+func (s semaphore) tryAcquire() bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	<-s
+}
+
+// limitScopes caps the number of concurrent retrievals for each dag-scope,
+// so that expensive full DAG retrievals can't starve cheap block retrievals.
+// Requests over a scope's limit get 503. A limit of 0 leaves a scope
+// unlimited.
+func limitScopes(limits map[string]uint, next http.HandlerFunc) http.HandlerFunc {
+	semaphores := make(map[string]semaphore)
+	for scope, limit := range limits {
+		if limit > 0 {
+			semaphores[scope] = make(semaphore, limit)
+		}
+	}
+	if len(semaphores) == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope := r.URL.Query().Get("dag-scope")
+		if scope == "" {
+			scope = "all"
+		}
+		sem, ok := semaphores[scope]
+		if !ok {
+			next(w, r)
+			return
+		}
+		if !sem.tryAcquire() {
+			logger.Debugw("rejecting retrieval, too many concurrent retrievals for scope", "scope", scope, "limit", cap(sem))
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("too many concurrent dag-scope=%s retrievals", scope), http.StatusServiceUnavailable)
+			return
+		}
+		defer sem.release()
+		next(w, r)
+	}
+}
+
+// limitRetrievals caps the number of retrievals in flight across all scopes.
+// It wraps the retrieval handler inside the cache, so requests served from
+// the cache don't count against the limit. Requests over the limit get 503.
+// A limit of 0 is unlimited.
+func limitRetrievals(limit uint, next http.HandlerFunc) http.HandlerFunc {
+	if limit == 0 {
+		return next
+	}
+	sem := make(semaphore, limit)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !sem.tryAcquire() {
+			logger.Debugw("rejecting retrieval, too many concurrent retrievals", "limit", limit)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent retrievals", http.StatusServiceUnavailable)
+			return
+		}
+		defer sem.release()
+		next(w, r)
+	}
+}
+
+// limitRequestMetadata rejects requests whose URL and headers together exceed
+// maxBytes with 431, bounding the aggregate size that the separate URL and
+// header limits each allow
+func limitRequestMetadata(maxBytes uint64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if size := requestMetadataSize(r); size > maxBytes {
+			logger.Debugw("rejecting request, URL and headers too large", "size", size, "max", maxBytes)
+			http.Error(w, "request URL and headers too large", http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestMetadataSize approximates the size of a request's URL and headers
+// as sent on the wire
+func requestMetadataSize(r *http.Request) uint64 {
+	size := uint64(len(r.RequestURI))
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += uint64(len(name) + len(value) + len(": \r\n"))
+		}
+	}
+	return size
+}
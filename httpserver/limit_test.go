@@ -0,0 +1,182 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimitScopes(t *testing.T) {
+	limits := map[string]uint{"all": 1, "entity": 2, "block": 0}
+	tests := []struct {
+		name string
+		// inFlight are the queries of retrievals already running
+		inFlight   []string
+		query      string
+		wantStatus int
+	}{
+		{name: "under the limit", query: "dag-scope=entity", wantStatus: http.StatusOK},
+		{name: "at the limit", inFlight: []string{"dag-scope=all"}, query: "dag-scope=all", wantStatus: http.StatusServiceUnavailable},
+		{name: "no scope is all", inFlight: []string{"dag-scope=all"}, query: "", wantStatus: http.StatusServiceUnavailable},
+		{name: "limits are per scope", inFlight: []string{"dag-scope=all"}, query: "dag-scope=entity", wantStatus: http.StatusOK},
+		{name: "second of two slots", inFlight: []string{"dag-scope=entity"}, query: "dag-scope=entity", wantStatus: http.StatusOK},
+		{name: "zero is unlimited", inFlight: []string{"dag-scope=block", "dag-scope=block"}, query: "dag-scope=block", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			started := make(chan struct{})
+			handler := limitScopes(limits, func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Hold") != "" {
+					started <- struct{}{}
+					<-release
+				}
+			})
+
+			done := make(chan struct{})
+			for _, query := range tt.inFlight {
+				req := httptest.NewRequest(http.MethodGet, "/ipfs/cid?"+query, nil)
+				req.Header.Set("X-Hold", "1")
+				go func() {
+					defer func() { done <- struct{}{} }()
+					handler(httptest.NewRecorder(), req)
+				}()
+				<-started
+			}
+
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/ipfs/cid?"+tt.query, nil))
+			close(release)
+			for range tt.inFlight {
+				<-done
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Error("missing Retry-After")
+			}
+		})
+	}
+}
+
+func TestLimitRetrievals(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      uint
+		inFlight   int
+		wantStatus int
+	}{
+		{name: "under the limit", limit: 2, inFlight: 1, wantStatus: http.StatusOK},
+		{name: "at the limit", limit: 2, inFlight: 2, wantStatus: http.StatusServiceUnavailable},
+		{name: "zero is unlimited", limit: 0, inFlight: 3, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			started := make(chan struct{})
+			handler := limitRetrievals(tt.limit, func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Hold") != "" {
+					started <- struct{}{}
+					<-release
+				}
+			})
+
+			done := make(chan struct{})
+			for i := 0; i < tt.inFlight; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/ipfs/cid", nil)
+				req.Header.Set("X-Hold", "1")
+				go func() {
+					defer func() { done <- struct{}{} }()
+					handler(httptest.NewRecorder(), req)
+				}()
+				<-started
+			}
+
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/ipfs/cid", nil))
+			close(release)
+			for i := 0; i < tt.inFlight; i++ {
+				<-done
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Error("missing Retry-After")
+			}
+
+			// slots are released once retrievals finish
+			rec = httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/ipfs/cid", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("status %d after retrievals finished, want %d", rec.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestLimitRetrievalsSkipsCacheHits(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir(), MaxConcurrentRequests: 1}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ipfs/"+otherTestRoot {
+			started <- struct{}{}
+			<-release
+		}
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+		_, _ = io.WriteString(w, "car")
+	})
+	get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil)
+
+	held := make(chan struct{})
+	go func() {
+		defer close(held)
+		if res, err := http.Get("http://" + srv.Addr() + "/ipfs/" + otherTestRoot); err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-started
+	defer func() {
+		close(release)
+		<-held
+	}()
+
+	if res, _ := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil); res.StatusCode != http.StatusOK {
+		t.Errorf("cache hit got status %d at the retrieval limit, want %d", res.StatusCode, http.StatusOK)
+	}
+	if res, _ := get(t, srv, http.MethodGet, "/ipfs/"+testRoot+"?dag-scope=entity", nil); res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("new retrieval got status %d at the retrieval limit, want %d", res.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestLimitRequestMetadata(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		header     string
+		maxBytes   uint64
+		wantStatus int
+	}{
+		{name: "small request", target: "/ipfs/cid", maxBytes: 1024, wantStatus: http.StatusOK},
+		{name: "long URL", target: "/ipfs/cid/" + strings.Repeat("a", 1024), maxBytes: 1024, wantStatus: http.StatusRequestHeaderFieldsTooLarge},
+		{name: "large header", target: "/ipfs/cid", header: strings.Repeat("b", 1024), maxBytes: 1024, wantStatus: http.StatusRequestHeaderFieldsTooLarge},
+		{name: "URL and header together", target: "/ipfs/cid/" + strings.Repeat("a", 520), header: strings.Repeat("b", 520), maxBytes: 1024, wantStatus: http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := limitRequestMetadata(tt.maxBytes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Padding", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "cassiopeia"
+
+// metrics holds the Prometheus collectors for the server, registered on a
+// registry of their own
+type metrics struct {
+	registry           *prometheus.Registry
+	requests           *prometheus.CounterVec
+	bytesServed        prometheus.Counter
+	cacheResults       *prometheus.CounterVec
+	retrievalsInFlight prometheus.Gauge
+	retrievalErrors    *prometheus.CounterVec
+}
+
+func newMetrics(extra ...prometheus.Collector) (*metrics, error) {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Total HTTP requests served, by status code.",
+		}, []string{"code"}),
+		bytesServed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_response_bytes_total",
+			Help:      "Total response body bytes served.",
+		}),
+		cacheResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_results_total",
+			Help:      "Cacheable requests by cache result: hit, miss or bypass.",
+		}, []string{"result"}),
+		retrievalsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "retrievals_in_flight",
+			Help:      "Retrievals from the network currently in progress.",
+		}),
+		retrievalErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "retrieval_errors_total",
+			Help:      "Retrievals from the network that failed, by status code.",
+		}, []string{"code"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.bytesServed,
+		m.cacheResults,
+		m.retrievalsInFlight,
+		m.retrievalErrors,
+	)
+	for _, c := range extra {
+		if err := m.registry.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// handler serves the metrics in the Prometheus exposition format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// instrument counts every request and the bytes served in response
+func (m *metrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &trackingWriter{ResponseWriter: w}
+		next.ServeHTTP(tw, r)
+		m.requests.WithLabelValues(statusLabel(tw.status)).Inc()
+		m.bytesServed.Add(float64(tw.written))
+	})
+}
+
+// instrumentRetrieval tracks retrievals in flight and counts those that fail
+func (m *metrics) instrumentRetrieval(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.retrievalsInFlight.Inc()
+		defer m.retrievalsInFlight.Dec()
+
+		tw := &trackingWriter{ResponseWriter: w}
+		next(tw, r)
+		if tw.status >= http.StatusBadRequest {
+			m.retrievalErrors.WithLabelValues(statusLabel(tw.status)).Inc()
+		}
+	}
+}
+
+func (m *metrics) observeCacheResult(result string) {
+	if result != "" {
+		m.cacheResults.WithLabelValues(strings.ToLower(result)).Inc()
+	}
+}
+
+// statusLabel returns the status code as a label, treating a response that
+// was never written as a 200 as net/http does
+func statusLabel(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return strconv.Itoa(status)
+}
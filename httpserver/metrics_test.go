@@ -0,0 +1,94 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		retrieve http.HandlerFunc
+		// cacheResult is the cache result recorded for the request, if any
+		cacheResult string
+		// wantLines are lines the metrics endpoint must serve afterwards
+		wantLines []string
+	}{
+		{
+			name:     "success",
+			retrieve: func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, "car") },
+			wantLines: []string{
+				`cassiopeia_http_requests_total{code="200"} 1`,
+				`cassiopeia_http_response_bytes_total 3`,
+				`cassiopeia_retrievals_in_flight 0`,
+			},
+		},
+		{
+			name:      "nothing written",
+			retrieve:  func(w http.ResponseWriter, r *http.Request) {},
+			wantLines: []string{`cassiopeia_http_requests_total{code="200"} 1`, `cassiopeia_http_response_bytes_total 0`},
+		},
+		{
+			name:     "retrieval error",
+			retrieve: func(w http.ResponseWriter, r *http.Request) { http.Error(w, "no candidates", http.StatusBadGateway) },
+			wantLines: []string{
+				`cassiopeia_http_requests_total{code="502"} 1`,
+				`cassiopeia_retrieval_errors_total{code="502"} 1`,
+				`cassiopeia_http_response_bytes_total 14`,
+			},
+		},
+		{
+			name:        "cache hit",
+			retrieve:    func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, "car") },
+			cacheResult: cacheHit,
+			wantLines:   []string{`cassiopeia_cache_results_total{result="hit"} 1`},
+		},
+		{
+			name:      "extra collector",
+			retrieve:  func(w http.ResponseWriter, r *http.Request) {},
+			wantLines: []string{`extra_total 0`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMetrics(prometheus.NewCounter(prometheus.CounterOpts{Name: "extra_total", Help: "An extra collector."}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := m.instrument(m.instrumentRetrieval(tt.retrieve))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil))
+			m.observeCacheResult(tt.cacheResult)
+
+			rec := httptest.NewRecorder()
+			m.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d serving metrics, want %d", rec.Code, http.StatusOK)
+			}
+			lines := strings.Split(rec.Body.String(), "\n")
+			for _, want := range tt.wantLines {
+				found := false
+				for _, line := range lines {
+					if line == want {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("metrics missing %q", want)
+				}
+			}
+		})
+	}
+}
+
+func TestMetricsDuplicateCollector(t *testing.T) {
+	extra := prometheus.NewCounter(prometheus.CounterOpts{Name: "extra_total", Help: "An extra collector."})
+	if _, err := newMetrics(extra, extra); err == nil {
+		t.Error("got no error registering a collector twice")
+	}
+}
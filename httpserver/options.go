@@ -0,0 +1,23 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ipfsAllowedMethods are the methods the /ipfs/ handler serves
+const ipfsAllowedMethods = "GET, HEAD, OPTIONS"
+
+// handleOptions answers OPTIONS requests for /ipfs/ paths with a 204 listing
+// the allowed methods, without reaching lassie or the cache. Other requests
+// are passed through untouched.
+func handleOptions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions || !strings.HasPrefix(r.URL.Path, ipfsPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Allow", ipfsAllowedMethods)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
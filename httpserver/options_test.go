@@ -0,0 +1,46 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantAllow  string
+		wantNext   bool
+	}{
+		{name: "options for /ipfs/", method: http.MethodOptions, target: "/ipfs/" + testRoot, wantStatus: http.StatusNoContent, wantAllow: ipfsAllowedMethods},
+		{name: "options for a path", method: http.MethodOptions, target: "/ipfs/" + testRoot + "/dir/file", wantStatus: http.StatusNoContent, wantAllow: ipfsAllowedMethods},
+		{name: "options elsewhere", method: http.MethodOptions, target: "/health", wantStatus: http.StatusOK, wantNext: true},
+		{name: "get for /ipfs/", method: http.MethodGet, target: "/ipfs/" + testRoot, wantStatus: http.StatusOK, wantNext: true},
+		{name: "head for /ipfs/", method: http.MethodHead, target: "/ipfs/" + testRoot, wantStatus: http.StatusOK, wantNext: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reachedNext bool
+			handler := handleOptions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reachedNext = true
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("got Allow %q, want %q", got, tt.wantAllow)
+			}
+			if reachedNext != tt.wantNext {
+				t.Errorf("got next handler reached %t, want %t", reachedNext, tt.wantNext)
+			}
+			if !tt.wantNext && rec.Body.Len() != 0 {
+				t.Errorf("got body %q, want none", rec.Body.String())
+			}
+		})
+	}
+}
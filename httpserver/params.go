@@ -0,0 +1,57 @@
+package httpserver
+
+import (
+	"net/http"
+	"sync"
+)
+
+// legacyParam is the current form of a deprecated trustless gateway query
+// parameter
+type legacyParam struct {
+	// name is the current name of the parameter
+	name string
+	// values maps deprecated values to their current ones, values that
+	// aren't in it are passed through unchanged
+	values map[string]string
+	// warn logs the deprecation once, rather than for every request
+	warn sync.Once
+}
+
+// legacyParams maps deprecated trustless gateway query parameters for the
+// scope and range of a request to their current forms
+var legacyParams = map[string]*legacyParam{
+	"car-scope": {name: "dag-scope", values: map[string]string{"file": "entity"}},
+	"bytes":     {name: "entity-bytes"},
+}
+
+// mapLegacyParams rewrites deprecated query parameters to their current forms
+// before the request reaches the cache, so that legacy and current requests
+// share cache entries. If both names are present the current one wins.
+func mapLegacyParams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		rewritten := false
+		for legacy, current := range legacyParams {
+			if !query.Has(legacy) {
+				continue
+			}
+			current.warn.Do(func() {
+				logger.Warnw("request uses deprecated query parameter, further uses won't be logged", "param", legacy, "replacement", current.name, "path", r.URL.Path)
+			})
+			if !query.Has(current.name) {
+				value := query.Get(legacy)
+				if mapped, ok := current.values[value]; ok {
+					value = mapped
+				}
+				query.Set(current.name, value)
+			}
+			query.Del(legacy)
+			rewritten = true
+		}
+		if rewritten {
+			r.URL.RawQuery = query.Encode()
+			r.RequestURI = r.URL.RequestURI()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
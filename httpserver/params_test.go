@@ -0,0 +1,45 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMapLegacyParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantQuery string
+	}{
+		{name: "car-scope all", query: "car-scope=all", wantQuery: "dag-scope=all"},
+		{name: "car-scope file", query: "car-scope=file", wantQuery: "dag-scope=entity"},
+		{name: "car-scope block", query: "car-scope=block", wantQuery: "dag-scope=block"},
+		{name: "bytes", query: "bytes=0:1023", wantQuery: "entity-bytes=0%3A1023"},
+		{name: "both legacy", query: "car-scope=file&bytes=0:*", wantQuery: "dag-scope=entity&entity-bytes=0%3A%2A"},
+		{name: "current wins over legacy", query: "car-scope=file&dag-scope=block", wantQuery: "dag-scope=block"},
+		{name: "other params kept", query: "car-scope=file&format=car", wantQuery: "dag-scope=entity&format=car"},
+		{name: "current untouched", query: "dag-scope=entity&entity-bytes=0:1023", wantQuery: "dag-scope=entity&entity-bytes=0:1023"},
+		{name: "no query", query: "", wantQuery: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			handler := mapLegacyParams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+			}))
+			target := "/ipfs/" + testRoot
+			if tt.query != "" {
+				target += "?" + tt.query
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+
+			if got.URL.RawQuery != tt.wantQuery {
+				t.Errorf("got query %q, want %q", got.URL.RawQuery, tt.wantQuery)
+			}
+			if got.RequestURI != got.URL.RequestURI() {
+				t.Errorf("got request URI %q, want %q", got.RequestURI, got.URL.RequestURI())
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+const ipfsPathPrefix = "/ipfs/"
+
+// normalizePaths cleans the path of /ipfs/ requests before they reach the
+// cache, so that equivalent paths such as /ipfs/<cid>/ and /ipfs/<cid> share a
+// single cache key. Paths that use ".." to escape the root CID are rejected.
+func normalizePaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ipfsPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cleaned, ok := cleanIpfsPath(r.URL.Path)
+		if !ok {
+			http.Error(w, "invalid path: escapes the root CID", http.StatusBadRequest)
+			return
+		}
+		if cleaned != r.URL.Path {
+			logger.Debugw("normalized request path", "from", r.URL.Path, "to", cleaned)
+			r.URL.Path = cleaned
+			r.URL.RawPath = ""
+			r.RequestURI = r.URL.RequestURI()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cleanIpfsPath collapses empty, "." and ".." segments of an /ipfs/ path and
+// drops any trailing slash. It returns false if a ".." segment would step
+// above the root CID.
+func cleanIpfsPath(p string) (string, bool) {
+	var segments []string
+	for _, segment := range strings.Split(strings.TrimPrefix(p, ipfsPathPrefix), "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) <= 1 {
+				return "", false
+			}
+			segments = segments[:len(segments)-1]
+		default:
+			segments = append(segments, segment)
+		}
+	}
+	return ipfsPathPrefix + strings.Join(segments, "/"), true
+}
+
+// validateRootCid rejects /ipfs/ requests whose first path segment is not a
+// valid CID before any cache lookup or retrieval work is done
+func validateRootCid(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ipfsPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		root, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, ipfsPathPrefix), "/")
+		if _, err := cid.Decode(root); err != nil {
+			logger.Debugw("rejecting request without a valid root CID", "path", r.URL.Path, "err", err)
+			http.Error(w, "missing or invalid CID", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,91 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCleanIpfsPath(t *testing.T) {
+	const root = "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	tests := []struct {
+		path   string
+		want   string
+		wantOk bool
+	}{
+		{path: root, want: root, wantOk: true},
+		{path: root + "/", want: root, wantOk: true},
+		{path: root + "//a///b/", want: root + "/a/b", wantOk: true},
+		{path: root + "/./a/.", want: root + "/a", wantOk: true},
+		{path: root + "/a/../b", want: root + "/b", wantOk: true},
+		{path: root + "/a/b/../..", want: root, wantOk: true},
+		{path: root + "/..", wantOk: false},
+		{path: root + "/a/../../b", wantOk: false},
+		{path: "/ipfs/../ipns/example.com", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := cleanIpfsPath(tt.path)
+			if ok != tt.wantOk {
+				t.Fatalf("cleanIpfsPath(%q) ok = %v, want %v", tt.path, ok, tt.wantOk)
+			}
+			if ok && got != tt.want {
+				t.Errorf("cleanIpfsPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePaths(t *testing.T) {
+	const root = "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantURI    string
+	}{
+		{name: "trailing slash", target: root + "/a/?dag-scope=entity", wantStatus: http.StatusOK, wantURI: root + "/a?dag-scope=entity"},
+		{name: "escapes the root", target: root + "/../other", wantStatus: http.StatusBadRequest},
+		{name: "not an ipfs path", target: "/health/", wantStatus: http.StatusOK, wantURI: "/health/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotURI string
+			handler := normalizePaths(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotURI = r.RequestURI
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotURI != tt.wantURI {
+				t.Errorf("request URI %q, want %q", gotURI, tt.wantURI)
+			}
+		})
+	}
+}
+
+func TestValidateRootCid(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{name: "CIDv1", target: "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/a", wantStatus: http.StatusOK},
+		{name: "CIDv0", target: "/ipfs/QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG", wantStatus: http.StatusOK},
+		{name: "invalid CID", target: "/ipfs/not-a-cid/a", wantStatus: http.StatusBadRequest},
+		{name: "missing CID", target: "/ipfs/", wantStatus: http.StatusBadRequest},
+		{name: "not an ipfs path", target: "/health", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := validateRootCid(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerPprof serves the net/http/pprof profiles under /debug/pprof/,
+// requiring the admin token
+func registerPprof(mux *http.ServeMux, adminToken string) {
+	protect := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !authorized(r, adminToken) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			handler(w, r)
+		}
+	}
+	mux.HandleFunc("/debug/pprof/", protect(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", protect(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", protect(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", protect(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", protect(pprof.Trace))
+}
@@ -0,0 +1,47 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+func TestPprof(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{name: "index", enabled: true, path: "/debug/pprof/", token: "secret", wantStatus: http.StatusOK},
+		{name: "profile by name", enabled: true, path: "/debug/pprof/goroutine?debug=1", token: "secret", wantStatus: http.StatusOK},
+		{name: "cmdline", enabled: true, path: "/debug/pprof/cmdline", token: "secret", wantStatus: http.StatusOK},
+		{name: "missing token", enabled: true, path: "/debug/pprof/", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", enabled: true, path: "/debug/pprof/cmdline", token: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "disabled", path: "/debug/pprof/", token: "secret", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startTestServer(t, HttpServerConfig{DisableCache: true, AdminToken: "secret", EnablePprof: tt.enabled}, (&stubRetrieval{}).retrieve)
+			header := http.Header{}
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if res, _ := get(t, srv, http.MethodGet, tt.path, header); res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestPprofRequiresAdminToken(t *testing.T) {
+	subscribe := func(types.RetrievalEventSubscriber) func() { return func() {} }
+	srv, err := newHttpServer(context.Background(), HttpServerConfig{Address: "127.0.0.1", TempDir: t.TempDir(), DisableCache: true, EnablePprof: true}, (&stubRetrieval{}).retrieve, subscribe)
+	if err == nil {
+		srv.Close()
+		t.Fatal("got no error enabling pprof without an admin token")
+	}
+}
@@ -0,0 +1,153 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/multiformats/go-multicodec"
+)
+
+// protocolNames are the retrieval protocols lassie supports, by the names
+// accepted in its protocols query parameter
+var protocolNames = map[string]multicodec.Code{
+	"bitswap":   multicodec.TransportBitswap,
+	"graphsync": multicodec.TransportGraphsyncFilecoinv1,
+	"http":      multicodec.TransportIpfsGatewayHttp,
+}
+
+// protocolToggles holds which of the retrieval protocols lassie was started
+// with are currently enabled. Disabled protocols are left out of the
+// protocols query parameter passed to lassie on each retrieval.
+type protocolToggles struct {
+	lock    sync.RWMutex
+	enabled map[string]bool
+}
+
+// newProtocolToggles enables the given protocols, or all protocols if none
+// are given, as lassie does
+func newProtocolToggles(protocols []multicodec.Code) *protocolToggles {
+	pt := &protocolToggles{enabled: make(map[string]bool)}
+	for name, code := range protocolNames {
+		if len(protocols) == 0 {
+			pt.enabled[name] = true
+		}
+		for _, p := range protocols {
+			if p == code {
+				pt.enabled[name] = true
+			}
+		}
+	}
+	return pt
+}
+
+// current returns the names of the enabled protocols, and whether any of
+// the available protocols are disabled
+func (pt *protocolToggles) current() (enabled []string, restricted bool) {
+	pt.lock.RLock()
+	defer pt.lock.RUnlock()
+	for name, on := range pt.enabled {
+		if on {
+			enabled = append(enabled, name)
+		} else {
+			restricted = true
+		}
+	}
+	sort.Strings(enabled)
+	return enabled, restricted
+}
+
+// apply restricts retrievals to the enabled protocols. It must run after
+// the cache, so that the injected parameter doesn't change the cache key.
+func (pt *protocolToggles) apply(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enabled, restricted := pt.current()
+		if !restricted {
+			next(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		if requested := query.Get("protocols"); requested != "" {
+			enabled = intersectProtocols(strings.Split(requested, ","), enabled)
+		}
+		if len(enabled) == 0 {
+			http.Error(w, "none of the requested retrieval protocols are enabled", http.StatusServiceUnavailable)
+			return
+		}
+		query.Set("protocols", strings.Join(enabled, ","))
+
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = query.Encode()
+		next(w, r)
+	}
+}
+
+func intersectProtocols(requested []string, enabled []string) []string {
+	var both []string
+	for _, name := range enabled {
+		for _, req := range requested {
+			if strings.TrimSpace(req) == name {
+				both = append(both, name)
+				break
+			}
+		}
+	}
+	return both
+}
+
+// handler reports the protocol toggles on GET and updates them on POST,
+// from a JSON object of protocol names to whether they are enabled, such as
+// {"graphsync": false}. Protocols lassie wasn't started with can't be
+// enabled.
+func (pt *protocolToggles) handler(accessToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, accessToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			var toggles map[string]bool
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&toggles); err != nil {
+				http.Error(w, "invalid protocol toggles: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := pt.set(toggles); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.Infow("updated retrieval protocols", "toggles", toggles)
+		}
+
+		pt.lock.RLock()
+		defer pt.lock.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(pt.enabled); err != nil {
+			logger.Warnw("failed to write retrieval protocols", "err", err)
+		}
+	}
+}
+
+func (pt *protocolToggles) set(toggles map[string]bool) error {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	for name := range toggles {
+		if _, ok := pt.enabled[name]; !ok {
+			return fmt.Errorf("protocol %q is unknown or was not enabled at startup", name)
+		}
+	}
+	for name, on := range toggles {
+		pt.enabled[name] = on
+	}
+	return nil
+}
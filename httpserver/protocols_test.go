@@ -0,0 +1,117 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/multiformats/go-multicodec"
+)
+
+func TestProtocolTogglesApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		started []multicodec.Code
+		toggles map[string]bool
+		query   string
+		// wantProtocols is the protocols parameter passed to lassie
+		wantProtocols string
+		wantStatus    int
+	}{
+		{name: "all enabled", wantStatus: http.StatusOK},
+		{name: "client choice kept when all enabled", query: "?protocols=graphsync", wantProtocols: "graphsync", wantStatus: http.StatusOK},
+		{name: "protocol turned off", toggles: map[string]bool{"graphsync": false}, wantProtocols: "bitswap,http", wantStatus: http.StatusOK},
+		{name: "protocol turned back on", toggles: map[string]bool{"graphsync": true}, wantStatus: http.StatusOK},
+		{name: "client choice narrowed", toggles: map[string]bool{"graphsync": false}, query: "?protocols=graphsync,http", wantProtocols: "http", wantStatus: http.StatusOK},
+		{name: "client choice all off", toggles: map[string]bool{"graphsync": false}, query: "?protocols=graphsync", wantStatus: http.StatusServiceUnavailable},
+		{name: "started with some", started: []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportIpfsGatewayHttp}, toggles: map[string]bool{"http": false}, wantProtocols: "bitswap", wantStatus: http.StatusOK},
+		{name: "everything off", started: []multicodec.Code{multicodec.TransportBitswap}, toggles: map[string]bool{"bitswap": false}, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt := newProtocolToggles(tt.started)
+			if err := pt.set(tt.toggles); err != nil {
+				t.Fatal(err)
+			}
+			var gotProtocols string
+			handler := pt.apply(func(w http.ResponseWriter, r *http.Request) {
+				gotProtocols = r.URL.Query().Get("protocols")
+			})
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotProtocols != tt.wantProtocols {
+				t.Errorf("got protocols %q passed to lassie, want %q", gotProtocols, tt.wantProtocols)
+			}
+		})
+	}
+}
+
+func TestProtocolsEndpoint(t *testing.T) {
+	var lk sync.Mutex
+	var gotProtocols string
+	srv := startTestServer(t, HttpServerConfig{DisableCache: true, AdminToken: "secret", Protocols: []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1}}, func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		defer lk.Unlock()
+		gotProtocols = r.URL.Query().Get("protocols")
+	})
+	admin := func(method, body, token string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, "http://"+srv.Addr()+"/admin/protocols", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var got map[string]bool
+		_ = json.NewDecoder(res.Body).Decode(&got)
+		b, _ := json.Marshal(got)
+		return res, string(b)
+	}
+
+	steps := []struct {
+		name       string
+		method     string
+		body       string
+		token      string
+		wantStatus int
+		// wantToggles is the reported toggles, if the request succeeds
+		wantToggles string
+		// wantProtocols is the protocols parameter lassie then retrieves with
+		wantProtocols string
+	}{
+		{name: "unauthorized", method: http.MethodPost, body: `{"graphsync": false}`, wantStatus: http.StatusUnauthorized},
+		{name: "report", method: http.MethodGet, token: "secret", wantStatus: http.StatusOK, wantToggles: `{"bitswap":true,"graphsync":true}`},
+		{name: "turn off", method: http.MethodPost, body: `{"graphsync": false}`, token: "secret", wantStatus: http.StatusOK, wantToggles: `{"bitswap":true,"graphsync":false}`, wantProtocols: "bitswap"},
+		{name: "not started with", method: http.MethodPost, body: `{"http": true}`, token: "secret", wantStatus: http.StatusBadRequest, wantProtocols: "bitswap"},
+		{name: "invalid body", method: http.MethodPost, body: `graphsync`, token: "secret", wantStatus: http.StatusBadRequest, wantProtocols: "bitswap"},
+		{name: "turn on", method: http.MethodPost, body: `{"graphsync": true}`, token: "secret", wantStatus: http.StatusOK, wantToggles: `{"bitswap":true,"graphsync":true}`},
+	}
+	for _, step := range steps {
+		res, toggles := admin(step.method, step.body, step.token)
+		if res.StatusCode != step.wantStatus {
+			t.Fatalf("%s: got status %d, want %d", step.name, res.StatusCode, step.wantStatus)
+		}
+		if step.wantToggles != "" && toggles != step.wantToggles {
+			t.Errorf("%s: got toggles %s, want %s", step.name, toggles, step.wantToggles)
+		}
+
+		get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil)
+		lk.Lock()
+		if gotProtocols != step.wantProtocols {
+			t.Errorf("%s: got protocols %q passed to lassie, want %q", step.name, gotProtocols, step.wantProtocols)
+		}
+		lk.Unlock()
+	}
+}
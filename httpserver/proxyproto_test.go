@@ -0,0 +1,131 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// proxyV2Header builds a v2 header with the given command, family and
+// address block
+func proxyV2Header(command, family byte, addrs []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family<<4|0x1)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4Addrs := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6Addrs := make([]byte, 36)
+	copy(ipv6Addrs, net.ParseIP("2001:db8::1"))
+	copy(ipv6Addrs[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(ipv6Addrs[32:], 56324)
+	binary.BigEndian.PutUint16(ipv6Addrs[34:], 443)
+
+	tests := []struct {
+		name     string
+		header   []byte
+		wantAddr string
+		// wantNil is set for headers that carry no client address
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "v1 TCP4", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), wantAddr: "192.0.2.1:56324"},
+		{name: "v1 TCP6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), wantAddr: "[2001:db8::1]:56324"},
+		{name: "v1 UNKNOWN", header: []byte("PROXY UNKNOWN\r\n"), wantNil: true},
+		{name: "v1 missing fields", header: []byte("PROXY TCP4 192.0.2.1 56324\r\n"), wantErr: true},
+		{name: "v1 invalid address", header: []byte("PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\n"), wantErr: true},
+		{name: "v1 invalid port", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n"), wantErr: true},
+		{name: "v1 without CRLF", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"), wantErr: true},
+		{name: "v1 too long", header: append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), 120)...), wantErr: true},
+		{name: "v2 IPv4", header: proxyV2Header(0x1, 0x1, ipv4Addrs), wantAddr: "192.0.2.1:56324"},
+		{name: "v2 IPv6", header: proxyV2Header(0x1, 0x2, ipv6Addrs), wantAddr: "[2001:db8::1]:56324"},
+		{name: "v2 IPv4 with TLVs", header: proxyV2Header(0x1, 0x1, append(append([]byte(nil), ipv4Addrs...), 0x04, 0x00, 0x01, 0xff)), wantAddr: "192.0.2.1:56324"},
+		{name: "v2 LOCAL", header: proxyV2Header(0x0, 0x0, nil), wantNil: true},
+		{name: "v2 unix socket", header: proxyV2Header(0x1, 0x3, make([]byte, 216)), wantNil: true},
+		{name: "v2 short IPv4 addresses", header: proxyV2Header(0x1, 0x1, ipv4Addrs[:8]), wantErr: true},
+		{name: "v2 unsupported command", header: proxyV2Header(0x2, 0x1, ipv4Addrs), wantErr: true},
+		{name: "v2 truncated", header: proxyV2Header(0x1, 0x1, ipv4Addrs)[:20], wantErr: true},
+		{name: "no header", header: []byte("GET / HTTP/1.1\r\n"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(tt.header), bytes.NewReader([]byte("GET"))))
+			addr, err := readProxyHeader(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got address %v, want an error", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantNil {
+				if addr != nil {
+					t.Fatalf("got address %v, want none", addr)
+				}
+			} else if addr == nil || addr.String() != tt.wantAddr {
+				t.Fatalf("got address %v, want %s", addr, tt.wantAddr)
+			}
+			// the header must be consumed exactly, leaving the request
+			rest, _ := io.ReadAll(r)
+			if string(rest) != "GET" {
+				t.Errorf("left %q after the header, want %q", rest, "GET")
+			}
+		})
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		peer       string
+		sent       string
+		wantRemote string
+		wantErr    bool
+	}{
+		{name: "trusted upstream", peer: "10.0.0.1", sent: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET", wantRemote: "192.0.2.1:56324"},
+		{name: "trusted upstream without a header", peer: "10.0.0.1", sent: "GET / HTTP/1.1\r\n", wantErr: true},
+		{name: "untrusted peer is served as is", peer: "192.0.2.9", sent: "GET", wantRemote: "192.0.2.9:40000"},
+		{name: "untrusted peer can't spoof", peer: "192.0.2.9", sent: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET", wantRemote: "192.0.2.9:40000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipes := newPipeListener()
+			l := proxyProtocolListener{Listener: pipes, trusted: trusted}
+			client := pipes.dial(tt.peer)
+			defer client.Close()
+			go func() { _, _ = client.Write([]byte(tt.sent)) }()
+
+			c, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			buf := make([]byte, 3)
+			_, err = io.ReadFull(c, buf)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidProxyHeader) {
+					t.Fatalf("got %v, want %v", err, errInvalidProxyHeader)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.RemoteAddr().String(); got != tt.wantRemote {
+				t.Errorf("remote address %s, want %s", got, tt.wantRemote)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/darkweak/souin/pkg/middleware"
+	"github.com/ipfs/go-cid"
+)
+
+const purgePathPrefix = "/purge/"
+
+// purgeHandler evicts every cached response for a root CID, whatever path,
+// query or Accept variant it was stored under. It responds 204 if anything
+// was evicted and 404 otherwise.
+func purgeHandler(cacher *middleware.SouinBaseHandler, accessToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, accessToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		raw := strings.TrimPrefix(r.URL.Path, purgePathPrefix)
+		root, err := cid.Decode(raw)
+		if err != nil {
+			http.Error(w, "missing or invalid CID", http.StatusBadRequest)
+			return
+		}
+
+		var purged int
+		for _, key := range cacher.Storer.ListKeys() {
+			// keys hold the CID as it was requested, which may not be the
+			// form it was given in here
+			if cacheKeyHasRoot(key, raw) || cacheKeyHasRoot(key, root.String()) {
+				cacher.Storer.Delete(key)
+				purged++
+			}
+		}
+		logger.Infow("purged cache entries", "cid", root, "entries", purged)
+		if purged == 0 {
+			http.Error(w, "no cache entries found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// cacheKeyHasRoot reports whether a Souin cache key is for a request under
+// /ipfs/<root>. Keys embed the request path, followed by the query and any
+// Vary suffix.
+func cacheKeyHasRoot(key string, root string) bool {
+	prefix := ipfsPathPrefix + root
+	for {
+		i := strings.Index(key, prefix)
+		if i < 0 {
+			return false
+		}
+		key = key[i+len(prefix):]
+		if key == "" || strings.ContainsRune("/?{-", rune(key[0])) {
+			return true
+		}
+	}
+}
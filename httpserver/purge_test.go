@@ -0,0 +1,127 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/darkweak/souin/pkg/middleware"
+)
+
+const otherTestRoot = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+
+func TestPurgeHandler(t *testing.T) {
+	// keys are in the shape Souin stores them, the scheme, path and query
+	// followed by the Accept header
+	keys := []string{
+		"http-/ipfs/" + testRoot + "-application/vnd.ipld.car",
+		"http-/ipfs/" + testRoot + "/a/b?dag-scope=entity-application/vnd.ipld.car",
+		"STALE_http-/ipfs/" + testRoot + "-application/vnd.ipld.car",
+		"http-/ipfs/" + otherTestRoot + "-application/vnd.ipld.car",
+	}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+		wantKeys   []string
+	}{
+		{
+			name:       "purges every entry for the root",
+			method:     http.MethodPost,
+			path:       "/purge/" + testRoot,
+			token:      "secret",
+			wantStatus: http.StatusNoContent,
+			wantKeys:   []string{"http-/ipfs/" + otherTestRoot + "-application/vnd.ipld.car"},
+		},
+		{
+			name:       "nothing cached for the root",
+			method:     http.MethodPost,
+			path:       "/purge/bafkreiaxnnnb7qz2focittuqq3ya25q7rcv3bqynnczfzako47346wosmu",
+			token:      "secret",
+			wantStatus: http.StatusNotFound,
+			wantKeys:   keys,
+		},
+		{
+			name:       "missing token",
+			method:     http.MethodPost,
+			path:       "/purge/" + testRoot,
+			wantStatus: http.StatusUnauthorized,
+			wantKeys:   keys,
+		},
+		{
+			name:       "wrong token",
+			method:     http.MethodPost,
+			path:       "/purge/" + testRoot,
+			token:      "guess",
+			wantStatus: http.StatusUnauthorized,
+			wantKeys:   keys,
+		},
+		{
+			name:       "invalid CID",
+			method:     http.MethodPost,
+			path:       "/purge/not-a-cid",
+			token:      "secret",
+			wantStatus: http.StatusBadRequest,
+			wantKeys:   keys,
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			path:       "/purge/" + testRoot,
+			token:      "secret",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantKeys:   keys,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storer := newFakeStorer(keys...)
+			handler := purgeHandler(&middleware.SouinBaseHandler{Storer: storer}, "secret")
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			got := storer.ListKeys()
+			want := newFakeStorer(tt.wantKeys...).ListKeys()
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("got keys %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCacheKeyHasRoot(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want bool
+	}{
+		{name: "root", key: "http-/ipfs/" + testRoot + "-application/vnd.ipld.car", want: true},
+		{name: "https root", key: "https-/ipfs/" + testRoot + "-application/vnd.ipld.car", want: true},
+		{name: "no Accept header", key: "http-/ipfs/" + testRoot + "-", want: true},
+		{name: "path", key: "http-/ipfs/" + testRoot + "/a/b.txt-application/vnd.ipld.car", want: true},
+		{name: "query", key: "http-/ipfs/" + testRoot + "?format=car-", want: true},
+		{name: "stale entry", key: "STALE_http-/ipfs/" + testRoot + "-application/vnd.ipld.car", want: true},
+		{name: "vary suffix", key: "http-/ipfs/" + testRoot + "-application/vnd.ipld.car{-VARY-}Accept-Encoding:gzip", want: true},
+		{name: "other root", key: "http-/ipfs/" + otherTestRoot + "-application/vnd.ipld.car", want: false},
+		{name: "longer root", key: "http-/ipfs/" + testRoot + "aa-application/vnd.ipld.car", want: false},
+		{name: "root only in the query", key: "http-/ipfs/" + otherTestRoot + "?root=" + testRoot + "-", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cacheKeyHasRoot(tt.key, testRoot); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverRetrieval converts a panic in a retrieval handler into a 500
+// response and logs the stack, rather than crashing the daemon. If streaming
+// has already begun the response is aborted instead, so the client sees a
+// broken stream rather than a truncated one that looks complete.
+func recoverRetrieval(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tw := &trackingWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			logger.Errorw("recovered from panic during retrieval", "path", r.URL.Path, "panic", p, "stack", string(debug.Stack()))
+			if tw.wroteHeader() {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(tw, "internal error during retrieval", http.StatusInternalServerError)
+		}()
+		next(tw, r)
+	}
+}
+
+// recoverCacher runs serve, which passes the request through the cache, and
+// recovers if the cache middleware panics. It reports whether the request
+// should be served again without the cache, which is only possible if
+// nothing has been written to the client yet.
+func recoverCacher(w *cacheResultWriter, r *http.Request, serve func()) (fallback bool) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		if p == http.ErrAbortHandler || w.result != "" {
+			panic(p)
+		}
+		logger.Errorw("recovered from panic in cache, serving without it", "path", r.URL.Path, "panic", p, "stack", string(debug.Stack()))
+		fallback = true
+	}()
+	serve()
+	return false
+}
+
+// errUpstreamPanic is returned to the cache in place of a response when
+// serving a cache miss panics
+var errUpstreamPanic = errors.New("panic while serving a cache miss")
+
+// recoverUpstream runs serve, which fills a cache miss, and recovers if it
+// panics. The cache calls it from its own goroutines, out of reach of the
+// server's recovery, so a panic there would crash the daemon. The panic is
+// reported as errUpstreamPanic, which the cache neither stores nor sends.
+func recoverUpstream(r *http.Request, serve func()) (err error) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		// an aborted retrieval has already been logged, but the response
+		// the cache buffered for it is incomplete
+		if p != http.ErrAbortHandler {
+			logger.Errorw("recovered from panic serving cache miss", "path", r.URL.Path, "panic", p, "stack", string(debug.Stack()))
+		}
+		err = errUpstreamPanic
+	}()
+	serve()
+	return nil
+}
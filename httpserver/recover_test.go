@@ -0,0 +1,131 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRecoverRetrieval(t *testing.T) {
+	tests := []struct {
+		name       string
+		retrieve   http.HandlerFunc
+		wantStatus int
+		// wantAbort is whether the response is aborted rather than completed
+		wantAbort bool
+	}{
+		{
+			name:       "no panic",
+			retrieve:   func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, "car") },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "panic before the headers",
+			retrieve:   func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "panic after the headers",
+			retrieve: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "partial")
+				panic("boom")
+			},
+			wantStatus: http.StatusOK,
+			wantAbort:  true,
+		},
+		{
+			name:       "aborted retrieval",
+			retrieve:   func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) },
+			wantStatus: http.StatusOK,
+			wantAbort:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			var aborted bool
+			func() {
+				defer func() {
+					p := recover()
+					if p != nil && p != http.ErrAbortHandler {
+						t.Fatalf("got panic %v, want none or %v", p, http.ErrAbortHandler)
+					}
+					aborted = p != nil
+				}()
+				recoverRetrieval(tt.retrieve)(rec, httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil))
+			}()
+			if aborted != tt.wantAbort {
+				t.Errorf("got aborted %t, want %t", aborted, tt.wantAbort)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRecoverUpstream(t *testing.T) {
+	tests := []struct {
+		name    string
+		serve   func()
+		wantErr error
+	}{
+		{name: "no panic", serve: func() {}},
+		{name: "panic", serve: func() { panic("boom") }, wantErr: errUpstreamPanic},
+		{name: "aborted retrieval", serve: func() { panic(http.ErrAbortHandler) }, wantErr: errUpstreamPanic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := recoverUpstream(httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil), tt.serve)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCacheMissPanic(t *testing.T) {
+	tests := []struct {
+		name string
+		// retrieve panics, after streaming part of the response if
+		// wroteHeader is set
+		wroteHeader bool
+	}{
+		{name: "panic before the response"},
+		{name: "panic while streaming", wroteHeader: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retrieval := &stubRetrieval{body: "car"}
+			var panicked atomic.Bool
+			srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir()}, func(w http.ResponseWriter, r *http.Request) {
+				if !panicked.CompareAndSwap(false, true) {
+					retrieval.retrieve(w, r)
+					return
+				}
+				if tt.wroteHeader {
+					w.WriteHeader(http.StatusOK)
+					_, _ = io.WriteString(w, "partial")
+				}
+				panic("boom")
+			})
+
+			// the cache buffers misses, so the client is still told of the
+			// failure, and it isn't cached
+			res, _ := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil)
+			if res.StatusCode != http.StatusInternalServerError {
+				t.Errorf("got status %d, want %d", res.StatusCode, http.StatusInternalServerError)
+			}
+			res, body := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil)
+			if res.StatusCode != http.StatusOK || body != "car" {
+				t.Errorf("got status %d with body %q after the panic, want %d with %q", res.StatusCode, body, http.StatusOK, "car")
+			}
+			if calls := retrieval.calls.Load(); calls != 1 {
+				t.Errorf("retrieved %d times after the panic, want 1", calls)
+			}
+		})
+	}
+}
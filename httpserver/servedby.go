@@ -0,0 +1,47 @@
+package httpserver
+
+import "net/http"
+
+// servedBy adds an X-Served-By header identifying this node to every
+// response. The header is set when the status is written so that it
+// replaces any copy stored in a cache shared with other nodes.
+func servedBy(id string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&headerWriter{ResponseWriter: w, name: "X-Served-By", value: id}, r)
+	})
+}
+
+// headerWriter sets a header as the response status is written, overriding
+// any value set by the handler
+type headerWriter struct {
+	http.ResponseWriter
+	name        string
+	value       string
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set(w.name, w.value)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
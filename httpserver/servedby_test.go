@@ -0,0 +1,45 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestServedBy(t *testing.T) {
+	tests := []struct {
+		name     string
+		servedBy string
+		path     string
+		// requests is how many times path is requested, later requests
+		// are served from the cache
+		requests int
+		// otherNode has the retrieval set its own X-Served-By, as a
+		// response stored in a cache shared with another node would have
+		otherNode bool
+		want      string
+	}{
+		{name: "not configured", path: "/ipfs/" + testRoot, requests: 1},
+		{name: "retrieval", servedBy: "node-a", path: "/ipfs/" + testRoot, requests: 1, want: "node-a"},
+		{name: "replaces another node's header", servedBy: "node-a", path: "/ipfs/" + testRoot, requests: 1, otherNode: true, want: "node-a"},
+		{name: "cache hit", servedBy: "node-a", path: "/ipfs/" + testRoot, requests: 2, otherNode: true, want: "node-a"},
+		{name: "error response", servedBy: "node-a", path: "/nope", requests: 1, want: "node-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir(), ServedBy: tt.servedBy}, func(w http.ResponseWriter, r *http.Request) {
+				if tt.otherNode {
+					w.Header().Set("X-Served-By", "node-b")
+				}
+				w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+				_, _ = io.WriteString(w, "car")
+			})
+			for i := 0; i < tt.requests; i++ {
+				res, _ := get(t, srv, http.MethodGet, tt.path, nil)
+				if got := res.Header.Get("X-Served-By"); got != tt.want {
+					t.Errorf("request %d got X-Served-By %q, want %q", i+1, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -2,35 +2,190 @@ package httpserver
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/darkweak/souin/configurationtypes"
 	"github.com/darkweak/souin/pkg/middleware"
-	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/v3"
 	"github.com/filecoin-project/lassie/pkg/lassie"
 	lassiehttpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-log/v2"
 	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/multiformats/go-multicodec"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var logger = log.Logger("cassiopeia/httpserver")
 
+// DefaultCacheControl is the Cache-Control applied to responses that don't set
+// their own. Content addressed responses never change, so they are cached for
+// a year and marked immutable.
+const DefaultCacheControl = "public, max-age=31536000, immutable"
+
+// cacheTTL is the longest a response is kept in the cache, responses with a
+// shorter max-age are kept for that long. Souin caps every entry at its TTL,
+// expiring them as soon as they're stored when none is set.
+const cacheTTL = 365 * 24 * time.Hour
+
+const (
+	cacheOpenInitialBackoff = 250 * time.Millisecond
+	cacheOpenMaxBackoff     = 5 * time.Second
+)
+
 // HttpServer is a Lassie server for fetching data from the network via HTTP
 type HttpServer struct {
 	cancel   context.CancelFunc
 	ctx      context.Context
 	listener net.Listener
 	server   *http.Server
+	cacher   *middleware.SouinBaseHandler
+	active   *activeConns
+	gcDone   chan struct{}
+	// disk pauses caching while free disk space is low, if configured
+	disk *diskMonitor
+	// socketPath is the unix socket listened on, if any
+	socketPath string
+
+	shutdownTimeout time.Duration
 }
 
 type HttpServerConfig struct {
-	Address             string
-	Port                uint
-	TempDir             string
+	// Address is the address to listen on, or unix:/path/to/socket to
+	// listen on a unix domain socket
+	Address string
+	Port    uint
+	TempDir string
+	// CacheDir is where the badger cache is stored, falling back to TempDir
+	// if empty. It can't be the same directory as an explicitly set TempDir,
+	// where lassie writes its scratch files.
+	CacheDir            string
 	MaxBlocksPerRequest uint64
-	AccessToken         string
+	// Protocols are the retrieval protocols lassie was started with, all of
+	// them if empty. They can be toggled at runtime through /admin/protocols.
+	Protocols []multicodec.Code
+	// FlushInterval is the longest time written response data is held
+	// before being flushed to the client. The cache buffers the responses
+	// it stores, so it only takes effect for responses that bypass it, such
+	// as with DisableCache or for HEAD and Range requests.
+	FlushInterval time.Duration
+	// ShutdownTimeout bounds how long Close waits for in-flight requests
+	// before forcing their connections closed. Zero waits indefinitely.
+	ShutdownTimeout time.Duration
+	// AccessToken is the bearer token required in the Authorization header
+	// of /ipfs/ requests, which the AdminToken is also accepted in place of.
+	// Retrievals are open to anyone when it is empty.
+	AccessToken string
+	// AdminToken is the bearer token required by the administrative
+	// endpoints, which are not registered when it is empty
+	AdminToken    string
+	ListenNetwork string
+	// TLSCertFile and TLSKeyFile enable TLS when both are set
+	TLSCertFile   string
+	TLSKeyFile    string
+	MaxConnsPerIP uint
+	// ProxyProtocol decodes PROXY protocol v1 and v2 headers on connections
+	// from the ProxyProtocolTrusted CIDRs, so that the original client's
+	// address is seen by the server, and limited by MaxConnsPerIP.
+	ProxyProtocol        bool
+	ProxyProtocolTrusted []string
+	// AllowIPs and DenyIPs are CIDRs or IPs of clients that may or may not
+	// make requests. When AllowIPs is set only clients in it are served.
+	AllowIPs []string
+	DenyIPs  []string
+	// TrustedProxies are CIDRs or IPs of proxies whose X-Forwarded-For
+	// header is trusted to give the client IP checked against AllowIPs and
+	// DenyIPs
+	TrustedProxies []string
+	// MaxRequestMetadataBytes bounds the combined size of a request's URL and
+	// headers
+	MaxRequestMetadataBytes uint64
+	// MaxConcurrentRequests caps retrievals in flight across all scopes.
+	// Requests served from the cache don't count against it.
+	MaxConcurrentRequests uint
+	MaxConcurrentAll      uint
+	MaxConcurrentEntity   uint
+	MaxConcurrentBlock    uint
+	// DisableCache serves every request straight from lassie, without
+	// opening the cache
+	DisableCache bool
+	// CacheBackend is the cache storage to use, either "badger", stored in
+	// CacheDir, or "redis", shared through the server at RedisAddr
+	CacheBackend     string
+	RedisAddr        string
+	RedisPassword    string
+	CacheControl     string
+	CacheOpenRetries uint
+	CacheMinFreeDisk uint64
+	// CacheGCInterval is how often the badger value log is garbage
+	// collected, zero disables GC
+	CacheGCInterval time.Duration
+	// StaleWhileRevalidate is how long an expired cache entry may still be
+	// served while it is refreshed in the background
+	StaleWhileRevalidate time.Duration
+	// CacheAdmission is "always" (the default) to cache every cacheable
+	// response, or "second-hit" to only cache one on its second request
+	CacheAdmission string
+	NormalizePaths bool
+	LegacyParams   bool
+	AllowedAccept  []string
+	// Duplicates is the policy for duplicate blocks in CAR responses:
+	// "client" (the default) honours the dups parameter the client sends,
+	// "allow" always includes duplicates and "forbid" never does
+	Duplicates string
+	// ServerTiming is one of "off", "on" or "debug". In debug mode the
+	// Server-Timing header is only sent to requests bearing the AdminToken,
+	// which must be set.
+	ServerTiming string
+	// TimingBreakdown sends a JSON breakdown of the time spent in each
+	// phase of a request in a trailer, to requests with ?timing=true
+	// bearing the AdminToken, which must be set
+	TimingBreakdown bool
+	EmitXCache      bool
+	// Compress compresses /ipfs/ responses with brotli or gzip for clients
+	// that accept them
+	Compress bool
+	// ServedBy is sent in an X-Served-By header on every response, to
+	// identify the node behind a load balancer. No header is sent if empty.
+	ServedBy string
+	// HTTP10Mode is how HTTP/1.0 clients are served, one of "passthrough"
+	// (the default) to stream to them until the connection closes,
+	// "buffer" or "reject"
+	HTTP10Mode string
+	// HTTP10MaxBufferBytes bounds the response buffered for an HTTP/1.0
+	// client in "buffer" mode, larger responses get a 505. Defaults to
+	// 64 MiB if zero.
+	HTTP10MaxBufferBytes int
+	// MetricsPath is the path Prometheus metrics are served on, metrics
+	// are not served if it is empty
+	MetricsPath string
+	// MetricsCollectors are registered alongside the server's own metrics
+	MetricsCollectors []prometheus.Collector
+	// EnablePprof serves profiles under /debug/pprof/ to requests bearing
+	// the AdminToken, which must be set
+	EnablePprof bool
+	// AccessLogFormat enables logging every request at INFO level, as
+	// "logfmt" or "json"
+	AccessLogFormat string
+	// InjectFirstByteLatency and InjectPerBlockLatency slow retrievals down
+	// artificially, for testing clients only. Cache hits are not delayed.
+	InjectFirstByteLatency time.Duration
+	InjectPerBlockLatency  time.Duration
+	// ProviderLatencyWindow enables the /admin/providers/latency endpoint,
+	// reporting provider time-to-first-byte over windows of this length. It
+	// requires the AdminToken.
+	ProviderLatencyWindow time.Duration
 }
 
 type contextKey struct {
@@ -43,54 +198,359 @@ func saveConnInCTX(ctx context.Context, c net.Conn) context.Context {
 	return context.WithValue(ctx, connContextKey, c)
 }
 
+// ConnFromContext returns the connection a request was received on, as
+// stored in the request context by the server
+func ConnFromContext(ctx context.Context) (net.Conn, bool) {
+	c, ok := ctx.Value(connContextKey).(net.Conn)
+	return c, ok
+}
+
 // NewHttpServer creates a new HttpServer
 func NewHttpServer(ctx context.Context, lassie *lassie.Lassie, cfg HttpServerConfig) (*HttpServer, error) {
-	addr := fmt.Sprintf("%s:%d", cfg.Address, cfg.Port)
-	listener, err := net.Listen("tcp", addr) // assigns a port if port is 0
+	lassieCfg := lassiehttpserver.HttpServerConfig{
+		Address:             cfg.Address,
+		Port:                cfg.Port,
+		TempDir:             cfg.TempDir,
+		MaxBlocksPerRequest: cfg.MaxBlocksPerRequest,
+	}
+	return newHttpServer(ctx, cfg, lassiehttpserver.IpfsHandler(lassie, lassieCfg), lassie.RegisterSubscriber)
+}
+
+// newHttpServer creates a new HttpServer serving /ipfs/ requests with the
+// retrieve handler, and registering for retrieval events with subscribe
+func newHttpServer(ctx context.Context, cfg HttpServerConfig, retrieve http.HandlerFunc, subscribe func(types.RetrievalEventSubscriber) func()) (*HttpServer, error) {
+	network := cfg.ListenNetwork
+	if network == "" {
+		network = "tcp"
+	}
+	// an address of unix:/path/to/socket listens on a unix domain socket
+	socketPath, unixSocket := strings.CutPrefix(cfg.Address, "unix:")
+	if unixSocket {
+		network = "unix"
+		if socketPath == "" {
+			return nil, errors.New("missing unix socket path in address")
+		}
+		// clients of a unix socket have no IP address to limit or filter on
+		if cfg.MaxConnsPerIP > 0 || len(cfg.AllowIPs) > 0 || len(cfg.DenyIPs) > 0 {
+			return nil, errors.New("per-IP connection limits and IP filters can't be used with a unix socket")
+		}
+	} else if err := validateListenNetwork(network, cfg.Address); err != nil {
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, errors.New("both a TLS certificate and key file must be provided to serve TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	cacheBackend := cfg.CacheBackend
+	if cacheBackend == "" {
+		cacheBackend = "badger"
+	}
+	switch cacheBackend {
+	case "badger":
+	case "redis":
+		if cfg.RedisAddr == "" {
+			return nil, errors.New("the redis cache backend requires a redis address")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported cache backend %q, must be one of badger or redis", cfg.CacheBackend)
+	}
+	switch cfg.ServerTiming {
+	case "", "off", "on":
+	case "debug":
+		if cfg.AdminToken == "" {
+			return nil, errors.New("the debug server timing mode requires an admin token")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported server timing mode %q, must be one of off, on or debug", cfg.ServerTiming)
+	}
+	if cfg.TimingBreakdown && cfg.AdminToken == "" {
+		return nil, errors.New("the timing breakdown requires an admin token")
+	}
+	if cfg.EnablePprof && cfg.AdminToken == "" {
+		return nil, errors.New("serving pprof profiles requires an admin token")
+	}
+	if cfg.ProviderLatencyWindow > 0 && cfg.AdminToken == "" {
+		return nil, errors.New("serving provider latencies requires an admin token")
+	}
+	cacheDir := cfg.CacheDir
+	if cacheDir == "" {
+		cacheDir = cfg.TempDir
+	} else if cfg.TempDir != "" && filepath.Clean(cacheDir) == filepath.Clean(cfg.TempDir) {
+		return nil, fmt.Errorf("cache directory %q is also the temp directory, lassie's scratch files could corrupt the cache; use a separate directory, or leave the cache directory unset to share it deliberately", cacheDir)
+	}
+	if cacheBackend == "badger" && !cfg.DisableCache {
+		if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	switch cfg.Duplicates {
+	case "", "client", "allow", "forbid":
+	default:
+		return nil, fmt.Errorf("unsupported duplicates policy %q, must be one of client, allow or forbid", cfg.Duplicates)
+	}
+	switch cfg.CacheAdmission {
+	case "", "always", "second-hit":
+	default:
+		return nil, fmt.Errorf("unsupported cache admission policy %q, must be one of always or second-hit", cfg.CacheAdmission)
+	}
+	switch cfg.AccessLogFormat {
+	case "", "logfmt", "json":
+	default:
+		return nil, fmt.Errorf("unsupported access log format %q, must be one of logfmt or json", cfg.AccessLogFormat)
+	}
+	switch cfg.HTTP10Mode {
+	case "", "passthrough", "buffer", "reject":
+	default:
+		return nil, fmt.Errorf("unsupported HTTP/1.0 mode %q, must be one of passthrough, buffer or reject", cfg.HTTP10Mode)
+	}
+
+	proxyTrusted, err := parseCIDRs(cfg.ProxyProtocolTrusted)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted PROXY protocol upstream: %w", err)
+	}
+	if cfg.ProxyProtocol && len(proxyTrusted) == 0 {
+		return nil, errors.New("the PROXY protocol requires at least one trusted upstream")
+	}
+
+	allowIPs, err := parseCIDRs(cfg.AllowIPs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed IPs: %w", err)
+	}
+	denyIPs, err := parseCIDRs(cfg.DenyIPs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denied IPs: %w", err)
+	}
+	trustedProxies, err := parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	addr := net.JoinHostPort(cfg.Address, strconv.FormatUint(uint64(cfg.Port), 10))
+	if unixSocket {
+		if err := removeStaleSocket(socketPath); err != nil {
+			return nil, err
+		}
+		addr = socketPath
+	} else {
+		socketPath = ""
+	}
+	listener, err := net.Listen(network, addr) // assigns a port if port is 0
 	if err != nil {
 		return nil, err
 	}
+	if cfg.ProxyProtocol {
+		listener = proxyProtocolListener{Listener: listener, trusted: proxyTrusted}
+	}
+	if cfg.MaxConnsPerIP > 0 {
+		listener = newConnLimitListener(listener, int(cfg.MaxConnsPerIP))
+	}
+	listener = countingListener{listener}
 
 	ctx, cancel := context.WithCancel(ctx)
 
+	metrics, err := newMetrics(cfg.MetricsCollectors...)
+	if err != nil {
+		cancel()
+		listener.Close()
+		return nil, err
+	}
+
 	// create server
 	mux := http.NewServeMux()
 
-	badgerConf := badger.DefaultOptions(cfg.TempDir)
-
+	cacheControl := cfg.CacheControl
+	if cacheControl == "" {
+		cacheControl = DefaultCacheControl
+	}
+	if cfg.StaleWhileRevalidate > 0 {
+		cacheControl = withStaleWhileRevalidate(cacheControl, cfg.StaleWhileRevalidate)
+	}
 	cacheConf := middleware.BaseConfiguration{
 		DefaultCache: &configurationtypes.DefaultCache{
-			AllowedHTTPVerbs: []string{"GET", "POST", "HEAD"},
-			Badger: configurationtypes.CacheProvider{
-				Configuration: badgerConf,
-			},
-			CacheName:   "Saturn",
-			Distributed: false,
+			// the key ignores the method, so only GET responses may be
+			// stored; anything else would be served in place of a GET
+			AllowedHTTPVerbs: []string{"GET"},
+			CacheName:        "Saturn",
 			Key: configurationtypes.Key{
 				DisableBody:   true,
 				DisableHost:   true,
 				DisableMethod: true,
-				DisableQuery:  false,
-				Headers:       []string{"Accept"},
-				Hide:          true,
+				// the query carries dag-scope and entity-bytes, which select
+				// different content for the same path, so it must stay in the key
+				DisableQuery: false,
+				Headers:      []string{"Accept"},
+				Hide:         true,
 			},
-			DefaultCacheControl: "public, max-age=31536000, immutable",
+			DefaultCacheControl: cacheControl,
+			TTL:                 configurationtypes.Duration{Duration: cacheTTL},
+			Stale:               configurationtypes.Duration{Duration: cfg.StaleWhileRevalidate},
 		},
 	}
-	cacher := middleware.NewHTTPCacheHandler(&cacheConf)
+	setCacheStorage(cacheConf.DefaultCache, cacheBackend, cacheDir, cfg)
+	var cacher *middleware.SouinBaseHandler
+	if !cfg.DisableCache {
+		var badgerDir string
+		if cacheBackend == "badger" {
+			badgerDir = cacheDir
+		}
+		cacher, err = newCacheHandler(&cacheConf, badgerDir, cfg.CacheOpenRetries)
+		if err != nil {
+			cancel()
+			listener.Close()
+			return nil, err
+		}
+	}
 
-	handler := servertiming.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cacher.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) error {
-			mux.ServeHTTP(w, r)
-			return nil
+	if cacher != nil && cfg.FlushInterval > 0 {
+		logger.Warnw("the flush interval only applies to responses that bypass the cache, which buffers the responses it stores", "flush_interval", cfg.FlushInterval)
+	}
+
+	var disk *diskMonitor
+	if cfg.CacheMinFreeDisk > 0 && cacheBackend == "badger" && cacher != nil {
+		disk = newDiskMonitor(cacheDir, cfg.CacheMinFreeDisk)
+		go disk.Run(ctx, diskCheckInterval)
+	}
+
+	// the cache is closed once GC has stopped, see Close
+	gcDone := make(chan struct{})
+	if cacher != nil && cfg.CacheGCInterval > 0 {
+		go func() {
+			defer close(gcDone)
+			runValueLogGC(ctx, valueLogGCers(cacher), cfg.CacheGCInterval)
+		}()
+	} else {
+		close(gcDone)
+	}
+
+	stats := &cacheStats{cacher: cacher}
+	var admission *admissionFilter
+	if cfg.CacheAdmission == "second-hit" {
+		admission = newAdmissionFilter()
+	}
+
+	// routes registered directly on rootMux bypass the cache
+	rootMux := http.NewServeMux()
+	rootMux.HandleFunc("/", requireAccessToken(cfg.AccessToken, cfg.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		cw := &cacheResultWriter{ResponseWriter: w, emitHeader: cfg.EmitXCache}
+		defer func() {
+			metrics.observeCacheResult(cw.result)
+			stats.observe(cw.result)
+		}()
+
+		// cache keys don't vary on Range or the method, so neither a partial
+		// response nor one to a method other than GET, such as a bodiless
+		// HEAD response, may be stored or served from a cached full GET;
+		// these are labelled as a BYPASS
+		if cacher == nil || r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+			mux.ServeHTTP(cw, r)
+			return
+		}
+		// while disk space is low, cached responses are still served but
+		// nothing new is stored
+		if (admission != nil && !admission.admit(admissionKey(r))) || (disk != nil && disk.Paused()) {
+			refuseStore(r)
+		}
+		if cfg.StaleWhileRevalidate > 0 {
+			acceptStale(r, cfg.StaleWhileRevalidate)
+		}
+		fallback := recoverCacher(cw, r, func() {
+			err := cacher.ServeHTTP(cw, r, func(w http.ResponseWriter, r *http.Request) error {
+				if cw.stale {
+					// the cache has served a stale entry and is refreshing
+					// it in the background
+					return recoverUpstream(r, func() { revalidateStale(ctx, cacher, cfg.StaleWhileRevalidate, w, r, mux) })
+				}
+				err := recoverUpstream(r, func() { mux.ServeHTTP(&serverErrorWriter{ResponseWriter: w, client: cw}, r) })
+				if cfg.StaleWhileRevalidate > 0 {
+					allowStale(w.Header(), cfg.StaleWhileRevalidate)
+				}
+				return err
+			})
+			if !errors.Is(err, errUpstreamPanic) {
+				return
+			}
+			// the cache buffers misses, so unless a server error has already
+			// been sent the client can still be told of the failure
+			if cw.result != "" {
+				panic(http.ErrAbortHandler)
+			}
+			cw.Header().Set("Cache-Control", "no-store")
+			http.Error(cw, "internal error during retrieval", http.StatusInternalServerError)
 		})
-	}), nil)
+		if fallback {
+			mux.ServeHTTP(cw, r)
+		}
+	}))
 
+	var handler http.Handler = validateRootCid(rootMux)
+	if cfg.Duplicates == "allow" || cfg.Duplicates == "forbid" {
+		handler = applyDuplicates(cfg.Duplicates, handler)
+	}
+	if len(cfg.AllowedAccept) > 0 {
+		handler = restrictAccept(cfg.AllowedAccept, handler)
+	}
+	handler = negotiateAccept(allowedMediaTypes(negotiableMediaTypes, cfg.AllowedAccept), handler)
+	if cfg.LegacyParams {
+		handler = mapLegacyParams(handler)
+	}
+	if cfg.NormalizePaths {
+		handler = normalizePaths(handler)
+	}
+	if cfg.MaxRequestMetadataBytes > 0 {
+		handler = limitRequestMetadata(cfg.MaxRequestMetadataBytes, handler)
+	}
+	handler = handleOptions(handler)
+	// compress before buffering for HTTP/1.0, which sets the Content-Length
+	if cfg.Compress {
+		handler = compressResponses(handler)
+	}
+	if cfg.HTTP10Mode == "buffer" || cfg.HTTP10Mode == "reject" {
+		http10MaxBufferBytes := cfg.HTTP10MaxBufferBytes
+		if http10MaxBufferBytes <= 0 {
+			http10MaxBufferBytes = defaultHTTP10MaxBufferBytes
+		}
+		handler = handleHTTP10(cfg.HTTP10Mode, http10MaxBufferBytes, handler)
+	}
+	if cfg.ServedBy != "" {
+		handler = servedBy(cfg.ServedBy, handler)
+	}
+	if len(allowIPs) > 0 || len(denyIPs) > 0 {
+		handler = filterIPs(allowIPs, denyIPs, trustedProxies, handler)
+	}
+	handler = metrics.instrument(handler)
+	if cfg.AccessLogFormat != "" {
+		handler = accessLog(cfg.AccessLogFormat, handler)
+	}
+	if cfg.TimingBreakdown {
+		handler = timingBreakdown(cfg.AdminToken, handler)
+	}
+	switch cfg.ServerTiming {
+	case "off":
+	case "", "on":
+		handler = servertiming.Middleware(handler, nil)
+	case "debug":
+		handler = debugServerTiming(cfg.AdminToken, handler)
+	}
+
+	active := newActiveConns()
 	server := &http.Server{
 		Addr:        fmt.Sprintf(":%d", cfg.Port),
 		BaseContext: func(listener net.Listener) context.Context { return ctx },
 		Handler:     handler,
 		ConnContext: saveConnInCTX,
+		ConnState: func(c net.Conn, state http.ConnState) {
+			active.connState(c, state)
+			logConnState(c, state)
+		},
+		TLSConfig: tlsConfig,
 	}
 
 	httpServer := &HttpServer{
@@ -98,21 +558,165 @@ func NewHttpServer(ctx context.Context, lassie *lassie.Lassie, cfg HttpServerCon
 		ctx:      ctx,
 		listener: listener,
 		server:   server,
+		cacher:   cacher,
+		active:   active,
+		gcDone:   gcDone,
+		disk:     disk,
+
+		socketPath: socketPath,
+
+		shutdownTimeout: cfg.ShutdownTimeout,
 	}
 
 	// Routes
-	lassieCfg := lassiehttpserver.HttpServerConfig{
-		Address:             cfg.Address,
-		Port:                cfg.Port,
-		TempDir:             cfg.TempDir,
-		MaxBlocksPerRequest: cfg.MaxBlocksPerRequest,
-		AccessToken:         cfg.AccessToken,
+	scopeLimits := map[string]uint{
+		"all":    cfg.MaxConcurrentAll,
+		"entity": cfg.MaxConcurrentEntity,
+		"block":  cfg.MaxConcurrentBlock,
+	}
+	protocols := newProtocolToggles(cfg.Protocols)
+	retrieve = metrics.instrumentRetrieval(recoverRetrieval(retrieve))
+	if cfg.InjectFirstByteLatency > 0 || cfg.InjectPerBlockLatency > 0 {
+		logger.Warnw("injecting latency into retrievals, this is for testing only", "first_byte", cfg.InjectFirstByteLatency, "per_block", cfg.InjectPerBlockLatency)
+		retrieve = injectLatency(cfg.InjectFirstByteLatency, cfg.InjectPerBlockLatency, retrieve)
+	}
+	mux.HandleFunc("/ipfs/", ensureFlusher(flushEvery(cfg.FlushInterval, limitRetrievals(cfg.MaxConcurrentRequests, limitScopes(scopeLimits, protocols.apply(retrieve))))))
+
+	rootMux.HandleFunc("/health", healthHandler)
+	if cfg.MetricsPath != "" {
+		rootMux.Handle(cfg.MetricsPath, metrics.handler())
+	}
+
+	if cfg.EnablePprof {
+		registerPprof(rootMux, cfg.AdminToken)
+	}
+	// purging is destructive and the stats expose what is being served, so
+	// both are only offered when they can be protected
+	if cacher != nil && cfg.AdminToken != "" {
+		rootMux.HandleFunc("/cache/stats", stats.handler(cfg.AdminToken))
+		rootMux.HandleFunc(purgePathPrefix, purgeHandler(cacher, cfg.AdminToken))
+	}
+
+	// toggling protocols affects every retrieval, so it is only offered
+	// when it can be protected
+	if cfg.AdminToken != "" {
+		rootMux.HandleFunc("/admin/protocols", protocols.handler(cfg.AdminToken))
+	}
+
+	if cfg.ProviderLatencyWindow > 0 {
+		latencies := newProviderLatencies(cfg.ProviderLatencyWindow)
+		subscribe(latencies.subscriber())
+		rootMux.HandleFunc("/admin/providers/latency", latencies.handler(cfg.AdminToken))
 	}
-	mux.HandleFunc("/ipfs/", lassiehttpserver.IpfsHandler(lassie, lassieCfg))
 
 	return httpServer, nil
 }
 
+// debugServerTiming only records and sends Server-Timing for requests
+// authorized with the admin token
+func debugServerTiming(adminToken string, next http.Handler) http.Handler {
+	timed := servertiming.Middleware(next, nil)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorized(r, adminToken) {
+			timed.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateListenNetwork checks that the network is one we can listen on and
+// that a literal IP address belongs to the network's address family
+func validateListenNetwork(network string, address string) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("unsupported listen network %q, must be one of tcp, tcp4 or tcp6", network)
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil
+	}
+	if network == "tcp4" && ip.To4() == nil {
+		return fmt.Errorf("cannot listen on IPv6 address %s with network tcp4", address)
+	}
+	if network == "tcp6" && ip.To4() != nil {
+		return fmt.Errorf("cannot listen on IPv4 address %s with network tcp6", address)
+	}
+	return nil
+}
+
+// setCacheStorage points the Souin cache at its storage backend
+func setCacheStorage(dc *configurationtypes.DefaultCache, backend string, cacheDir string, cfg HttpServerConfig) {
+	switch backend {
+	case "badger":
+		// Souin decodes the configuration from JSON into the options of the
+		// badger v3 it opens the cache with. The logger can't be decoded, so
+		// it is left out and Souin sets its own.
+		dc.Badger = configurationtypes.CacheProvider{
+			Configuration: badger.DefaultOptions(cacheDir).WithLogger(nil),
+		}
+	case "redis":
+		// Souin only uses redis for distributed caches. Its redis provider
+		// decodes the configuration into go-redis Options, and only falls
+		// back to the URL when there is no configuration, which is needed
+		// here to pass the password.
+		dc.Distributed = true
+		dc.Redis = configurationtypes.CacheProvider{
+			Configuration: map[string]interface{}{
+				"Addr":     cfg.RedisAddr,
+				"Password": cfg.RedisPassword,
+			},
+		}
+	}
+}
+
+// newCacheHandler creates the Souin cache handler, first waiting for the
+// badger directory to be free when badgerDir is set. Souin doesn't fail when
+// badger can't be opened, it logs the error and caches a storer without a
+// database for the directory, so the directory lock is probed here, retrying
+// with backoff while a previous process still holds it during a fast restart.
+func newCacheHandler(conf *middleware.BaseConfiguration, badgerDir string, retries uint) (*middleware.SouinBaseHandler, error) {
+	if badgerDir != "" {
+		if err := openWithRetries(retries, func() error { return probeBadger(badgerDir) }); err != nil {
+			return nil, err
+		}
+	}
+	return middleware.NewHTTPCacheHandler(conf), nil
+}
+
+// probeBadger opens and closes the badger database in dir, failing if
+// another process holds its directory lock. It must use the same badger
+// major version as Souin, as each rejects the other's manifest.
+func probeBadger(dir string) error {
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// openWithRetries calls open until it succeeds, up to retries more times
+// after the first attempt, backing off exponentially between attempts
+func openWithRetries(retries uint, open func() error) error {
+	backoff := cacheOpenInitialBackoff
+	for attempt := uint(0); ; attempt++ {
+		err := open()
+		if err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return fmt.Errorf("failed to open cache after %d attempts: %w", attempt+1, err)
+		}
+		logger.Warnw("failed to open cache, retrying", "attempt", attempt+1, "retries", retries, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > cacheOpenMaxBackoff {
+			backoff = cacheOpenMaxBackoff
+		}
+	}
+}
+
 // Addr returns the listening address of the server
 func (s HttpServer) Addr() string {
 	return s.listener.Addr().String()
@@ -120,8 +724,14 @@ func (s HttpServer) Addr() string {
 
 // Start starts the http server, returning an error if the server failed to start
 func (s *HttpServer) Start() error {
-	logger.Infow("starting http server", "listen_addr", s.listener.Addr())
-	err := s.server.Serve(s.listener)
+	var err error
+	if s.server.TLSConfig != nil {
+		logger.Infow("starting https server", "listen_addr", s.listener.Addr())
+		err = s.server.ServeTLS(s.listener, "", "")
+	} else {
+		logger.Infow("starting http server", "listen_addr", s.listener.Addr())
+		err = s.server.Serve(s.listener)
+	}
 	if err != http.ErrServerClosed {
 		logger.Errorw("failed to start http server", "err", err)
 		return err
@@ -130,9 +740,57 @@ func (s *HttpServer) Start() error {
 	return nil
 }
 
-// Close shutsdown the server and cancels the server context
+// Close shutsdown the server and then cancels the server context, which
+// in-flight requests run under, so that they get the chance to drain first.
+// The cache storage is closed last, so that nothing writes to it while it is
+// being flushed to disk.
 func (s *HttpServer) Close() error {
 	logger.Info("closing http server")
+	err := s.shutdown()
 	s.cancel()
-	return s.server.Shutdown(context.Background())
+	if s.socketPath != "" {
+		// closing the listener normally unlinks the socket already
+		if rerr := os.Remove(s.socketPath); rerr != nil && !errors.Is(rerr, fs.ErrNotExist) {
+			logger.Warnw("failed to remove unix socket", "path", s.socketPath, "err", rerr)
+		}
+	}
+	<-s.gcDone
+	if cerr := s.closeCache(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// shutdown gracefully shuts the server down, waiting for in-flight requests
+// for up to the shutdown timeout before closing their connections
+func (s *HttpServer) shutdown() error {
+	ctx := context.Background()
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.shutdownTimeout)
+		defer cancel()
+	}
+	err := s.server.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	logger.Warnw("graceful shutdown timed out, closing remaining connections", "timeout", s.shutdownTimeout, "dropped", s.active.Len())
+	return s.server.Close()
+}
+
+// closeCache closes the cache storage if it holds resources that need
+// closing, such as the badger database
+func (s *HttpServer) closeCache() error {
+	if s.cacher == nil {
+		return nil
+	}
+	closer, ok := s.cacher.Storer.(io.Closer)
+	if !ok {
+		return nil
+	}
+	logger.Info("closing cache storage")
+	if err := closer.Close(); err != nil {
+		return fmt.Errorf("failed to close cache storage: %w", err)
+	}
+	return nil
 }
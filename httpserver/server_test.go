@@ -0,0 +1,460 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/souin/pkg/middleware"
+	"github.com/darkweak/souin/pkg/storage"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+// testRoot is the root CID requested in tests
+const testRoot = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+
+// stubRetrieval stands in for lassie, serving the same body to every /ipfs/
+// request and counting the retrievals made
+type stubRetrieval struct {
+	body  string
+	calls atomic.Int64
+}
+
+func (s *stubRetrieval) retrieve(w http.ResponseWriter, r *http.Request) {
+	s.calls.Add(1)
+	w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+	w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+	_, _ = io.WriteString(w, s.body)
+}
+
+// startTestServer starts a server on a local port that retrieves with
+// retrieve, and closes it when the test ends
+func startTestServer(t *testing.T, cfg HttpServerConfig, retrieve http.HandlerFunc) *HttpServer {
+	t.Helper()
+	cfg.Address = "127.0.0.1"
+	if cfg.TempDir == "" {
+		cfg.TempDir = t.TempDir()
+	}
+	subscribe := func(types.RetrievalEventSubscriber) func() { return func() {} }
+	srv, err := newHttpServer(context.Background(), cfg, retrieve, subscribe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Start() }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv
+}
+
+// get makes a request to the server, returning the response with its body
+// read
+func get(t *testing.T, srv *HttpServer, method string, path string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, "http://"+srv.Addr()+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+func TestOpenWithRetries(t *testing.T) {
+	errLocked := errors.New("cannot acquire directory lock")
+	tests := []struct {
+		name      string
+		retries   uint
+		failures  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "opens first time", retries: 3, failures: 0, wantCalls: 1},
+		{name: "opens after a retry", retries: 3, failures: 1, wantCalls: 2},
+		{name: "gives up after the retries", retries: 1, failures: 2, wantCalls: 2, wantErr: true},
+		{name: "no retries", retries: 0, failures: 1, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := openWithRetries(tt.retries, func() error {
+				calls++
+				if calls <= tt.failures {
+					return errLocked
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("open called %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errLocked) {
+				t.Errorf("error %v doesn't wrap the last open error", err)
+			}
+		})
+	}
+}
+
+func TestCloseClosesCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	retrieval := &stubRetrieval{body: "car"}
+	srv := startTestServer(t, HttpServerConfig{CacheDir: cacheDir}, retrieval.retrieve)
+	if res, _ := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// badger holds a lock on its directory until it is closed
+	db, err := badger.Open(badger.DefaultOptions(cacheDir).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("cache can't be reopened after Close: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetCacheStorageRedis(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     string
+		password string
+	}{
+		{name: "with password", addr: "redis.example:6379", password: "secret"},
+		{name: "without password", addr: "127.0.0.1:6380"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := middleware.BaseConfiguration{DefaultCache: &configurationtypes.DefaultCache{}}
+			setCacheStorage(conf.DefaultCache, "redis", "", HttpServerConfig{RedisAddr: tt.addr, RedisPassword: tt.password})
+
+			// go-redis connects lazily, so this doesn't need a server
+			storer, err := storage.RedisConnectionFactory(&conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer storer.(*storage.Redis).Close()
+			opts := storer.(*storage.Redis).Options()
+			if opts.Addr != tt.addr {
+				t.Errorf("got address %q, want %q", opts.Addr, tt.addr)
+			}
+			if opts.Password != tt.password {
+				t.Errorf("got password %q, want %q", opts.Password, tt.password)
+			}
+			if !conf.DefaultCache.Distributed {
+				t.Error("redis cache isn't distributed, so Souin won't use it")
+			}
+		})
+	}
+}
+
+func TestNewCacheHandlerWaitsForLockedBadger(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries uint
+		wantErr bool
+	}{
+		{name: "released while retrying", retries: 3},
+		{name: "no retries", retries: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			// hold the directory lock as a previous process would, releasing
+			// it before the first retry
+			locked, err := badger.Open(badger.DefaultOptions(cacheDir).WithLogger(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			released := make(chan struct{})
+			go func() {
+				defer close(released)
+				time.Sleep(cacheOpenInitialBackoff / 2)
+				_ = locked.Close()
+			}()
+			defer func() { <-released }()
+
+			conf := middleware.BaseConfiguration{DefaultCache: &configurationtypes.DefaultCache{}}
+			setCacheStorage(conf.DefaultCache, "badger", cacheDir, HttpServerConfig{})
+			cacher, err := newCacheHandler(&conf, cacheDir, tt.retries)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("opened the cache while its directory was locked")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, ok := cacher.Storer.(*storage.Badger)
+			if !ok || db.DB == nil {
+				t.Fatal("cache storage has no badger database")
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCacheMissServerError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "internal error", status: http.StatusInternalServerError},
+		{name: "bad gateway", status: http.StatusBadGateway},
+		{name: "gateway timeout", status: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir()}, func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				http.Error(w, "retrieval failed", tt.status)
+			})
+
+			for i := 0; i < 2; i++ {
+				res, body := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil)
+				if res.StatusCode != tt.status || body != "retrieval failed\n" {
+					t.Errorf("got status %d with body %q, want %d with %q", res.StatusCode, body, tt.status, "retrieval failed\n")
+				}
+			}
+			if got := calls.Load(); got != 2 {
+				t.Errorf("retrieved %d times, want 2 as errors aren't cached", got)
+			}
+		})
+	}
+}
+
+func TestValidateListenNetwork(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address string
+		wantErr bool
+	}{
+		{name: "tcp with IPv4", network: "tcp", address: "127.0.0.1"},
+		{name: "tcp with IPv6", network: "tcp", address: "::1"},
+		{name: "tcp with hostname", network: "tcp", address: "localhost"},
+		{name: "tcp with any address", network: "tcp", address: ""},
+		{name: "tcp4 with IPv4", network: "tcp4", address: "0.0.0.0"},
+		{name: "tcp4 with IPv6", network: "tcp4", address: "::1", wantErr: true},
+		{name: "tcp4 with hostname", network: "tcp4", address: "localhost"},
+		{name: "tcp6 with IPv6", network: "tcp6", address: "::"},
+		{name: "tcp6 with IPv4", network: "tcp6", address: "127.0.0.1", wantErr: true},
+		{name: "tcp6 with any address", network: "tcp6", address: ""},
+		{name: "unsupported network", network: "udp", address: "127.0.0.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateListenNetwork(tt.network, tt.address)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCloseShutdownTimeout(t *testing.T) {
+	const shutdownTimeout = 200 * time.Millisecond
+	tests := []struct {
+		name string
+		// hang keeps the retrieval going until the request is cancelled,
+		// otherwise it finishes shortly after Close is called
+		hang       bool
+		wantClosed bool
+	}{
+		{name: "in-flight request drains"},
+		{name: "hung request is closed", hang: true, wantClosed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			srv := startTestServer(t, HttpServerConfig{DisableCache: true, ShutdownTimeout: shutdownTimeout}, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "c")
+				w.(http.Flusher).Flush()
+				close(started)
+				if tt.hang {
+					<-r.Context().Done()
+					return
+				}
+				time.Sleep(shutdownTimeout / 4)
+				_, _ = io.WriteString(w, "ar")
+			})
+
+			type result struct {
+				body string
+				err  error
+			}
+			results := make(chan result, 1)
+			go func() {
+				res, err := http.Get("http://" + srv.Addr() + "/ipfs/" + testRoot)
+				if err != nil {
+					results <- result{err: err}
+					return
+				}
+				defer res.Body.Close()
+				body, err := io.ReadAll(res.Body)
+				results <- result{body: string(body), err: err}
+			}()
+			<-started
+
+			start := time.Now()
+			if err := srv.Close(); err != nil {
+				t.Errorf("got error %v closing the server", err)
+			}
+			elapsed := time.Since(start)
+			if elapsed > shutdownTimeout+time.Second {
+				t.Errorf("Close took %s, want it bounded by the %s shutdown timeout", elapsed, shutdownTimeout)
+			}
+			if tt.wantClosed && elapsed < shutdownTimeout {
+				t.Errorf("Close took %s, want it to wait the %s shutdown timeout", elapsed, shutdownTimeout)
+			}
+
+			select {
+			case got := <-results:
+				if tt.wantClosed && got.err == nil {
+					t.Errorf("got body %q, want the connection closed mid-response", got.body)
+				}
+				if !tt.wantClosed && (got.err != nil || got.body != "car") {
+					t.Errorf("got body %q with error %v, want %q", got.body, got.err, "car")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("request still running after Close")
+			}
+		})
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	tests := []struct {
+		name         string
+		disableCache bool
+		method       string
+		header       http.Header
+	}{
+		{name: "cache disabled", disableCache: true, method: http.MethodGet},
+		{name: "range request", method: http.MethodGet, header: http.Header{"Range": []string{"bytes=0-1"}}},
+		{name: "head request", method: http.MethodHead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retrieval := &stubRetrieval{body: "car"}
+			cacheDir := t.TempDir()
+			srv := startTestServer(t, HttpServerConfig{CacheDir: cacheDir, DisableCache: tt.disableCache, EmitXCache: true}, retrieval.retrieve)
+
+			// bypass makes the request and checks that it reached the
+			// retrieval rather than the cache
+			bypass := func(step string) {
+				t.Helper()
+				before := retrieval.calls.Load()
+				res, _ := get(t, srv, tt.method, "/ipfs/"+testRoot, tt.header)
+				if got := res.Header.Get("X-Cache"); got != cacheBypass {
+					t.Errorf("%s got X-Cache %q, want %q", step, got, cacheBypass)
+				}
+				if got := retrieval.calls.Load() - before; got != 1 {
+					t.Errorf("%s made %d retrievals, want 1", step, got)
+				}
+			}
+			bypass("first request")
+			bypass("repeated request")
+
+			// nothing was stored, and a stored full response isn't used
+			wantFull := cacheMiss
+			if tt.disableCache {
+				wantFull = cacheBypass
+			}
+			res, _ := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil)
+			if got := res.Header.Get("X-Cache"); got != wantFull {
+				t.Errorf("full GET got X-Cache %q, want %q", got, wantFull)
+			}
+			bypass("request after a full GET")
+
+			if tt.disableCache {
+				if entries, err := os.ReadDir(cacheDir); err != nil || len(entries) > 0 {
+					t.Errorf("got %d entries in the cache dir with error %v, want the cache never opened", len(entries), err)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheKeyDagScope(t *testing.T) {
+	// the body names the scope retrieved, so a response served for the
+	// wrong scope can be told apart
+	var calls atomic.Int64
+	srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir(), EmitXCache: true}, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+		_, _ = io.WriteString(w, "car "+r.URL.Query().Get("dag-scope"))
+	})
+
+	for _, scope := range []string{"block", "all"} {
+		for _, want := range []string{cacheMiss, cacheHit} {
+			res, body := get(t, srv, http.MethodGet, "/ipfs/"+testRoot+"?dag-scope="+scope, nil)
+			if res.StatusCode != http.StatusOK || body != "car "+scope {
+				t.Errorf("dag-scope=%s got status %d with body %q, want %d with %q", scope, res.StatusCode, body, http.StatusOK, "car "+scope)
+			}
+			if got := res.Header.Get("X-Cache"); got != want {
+				t.Errorf("dag-scope=%s got X-Cache %q, want %q", scope, got, want)
+			}
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("retrieved %d times, want 2 as each scope has its own entry", got)
+	}
+}
+
+func TestCacheDirCollidesWithTempDir(t *testing.T) {
+	tempDir := t.TempDir()
+	tests := []struct {
+		name     string
+		cacheDir string
+		wantErr  bool
+	}{
+		{name: "same directory", cacheDir: tempDir, wantErr: true},
+		{name: "trailing slash", cacheDir: tempDir + "/", wantErr: true},
+		{name: "unclean path", cacheDir: filepath.Join(tempDir, "cache") + "/..", wantErr: true},
+		{name: "subdirectory", cacheDir: filepath.Join(tempDir, "cache")},
+		{name: "unset shares the temp directory", cacheDir: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscribe := func(types.RetrievalEventSubscriber) func() { return func() {} }
+			cfg := HttpServerConfig{Address: "127.0.0.1", TempDir: tempDir, CacheDir: tt.cacheDir}
+			srv, err := newHttpServer(context.Background(), cfg, (&stubRetrieval{}).retrieve, subscribe)
+			if err == nil {
+				_ = srv.Close()
+			}
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "also the temp directory") {
+				t.Errorf("got error %q, want it to explain the collision", err)
+			}
+		})
+	}
+}
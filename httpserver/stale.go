@@ -0,0 +1,118 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	souincontext "github.com/darkweak/souin/context"
+	"github.com/darkweak/souin/pkg/middleware"
+	"github.com/pquerna/cachecontrol/cacheobject"
+)
+
+// acceptStale lets the cache serve an expired entry for the request, by
+// adding max-stale to its Cache-Control. Souin only looks for an expired
+// entry when the request accepts one.
+func acceptStale(r *http.Request, maxStale time.Duration) {
+	cacheControl := r.Header.Get("Cache-Control")
+	if strings.Contains(cacheControl, "max-stale") {
+		return
+	}
+	if cacheControl != "" {
+		cacheControl += ", "
+	}
+	r.Header.Set("Cache-Control", cacheControl+"max-stale="+deltaSeconds(maxStale))
+}
+
+// allowStale adds stale-while-revalidate to the Cache-Control of a response
+// about to be stored. Souin only serves an expired entry while refreshing it
+// when the entry carries the directive, otherwise it serves the entry as it
+// is until max-stale runs out.
+func allowStale(h http.Header, staleWhileRevalidate time.Duration) {
+	if cacheControl := h.Get("Cache-Control"); cacheControl != "" {
+		h.Set("Cache-Control", withStaleWhileRevalidate(cacheControl, staleWhileRevalidate))
+	}
+}
+
+// withStaleWhileRevalidate adds stale-while-revalidate to a Cache-Control
+// value that doesn't already set it
+func withStaleWhileRevalidate(cacheControl string, staleWhileRevalidate time.Duration) string {
+	if strings.Contains(cacheControl, "stale-while-revalidate") {
+		return cacheControl
+	}
+	return fmt.Sprintf("%s, stale-while-revalidate=%s", cacheControl, deltaSeconds(staleWhileRevalidate))
+}
+
+// deltaSeconds formats a duration as whole seconds for a Cache-Control
+// directive, rounding up so that a positive duration is never zero
+func deltaSeconds(d time.Duration) string {
+	return fmt.Sprint(int64(math.Ceil(d.Seconds())))
+}
+
+// revalidateStale refreshes an expired cache entry that Souin has already
+// served. Souin would buffer the fresh response in a buffer it has already
+// returned to its pool, on the request's context, which ends with the
+// response. The fresh response is instead buffered and stored here, on a
+// context that only ends with the server, and w is left with a status the
+// cache doesn't store.
+func revalidateStale(serverCtx context.Context, cacher *middleware.SouinBaseHandler, staleWhileRevalidate time.Duration, w http.ResponseWriter, r *http.Request, next http.Handler) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+	stop := context.AfterFunc(serverCtx, cancel)
+	defer stop()
+	r = r.WithContext(ctx)
+
+	rw := &revalidationWriter{header: make(http.Header)}
+	next.ServeHTTP(rw, r)
+	w.WriteHeader(http.StatusNotModified)
+	// a retrieval cut short by the server closing is incomplete
+	if ctx.Err() != nil {
+		return
+	}
+
+	cachedKey, ok := r.Context().Value(souincontext.Key).(string)
+	if !ok {
+		return
+	}
+	requestCc, err := cacheobject.ParseRequestCacheControl(r.Header.Get("Cache-Control"))
+	if err != nil {
+		return
+	}
+	allowStale(rw.header, staleWhileRevalidate)
+	cw := middleware.NewCustomWriter(r, rw, &rw.buf)
+	cw.WriteHeader(rw.status)
+	if err := cacher.Store(cw, r, requestCc, cachedKey); err != nil {
+		logger.Debugw("failed to refresh a stale cache entry", "path", r.URL.Path, "status", rw.status, "err", err)
+	}
+}
+
+// revalidationWriter buffers the response to a revalidation
+type revalidationWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *revalidationWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *revalidationWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *revalidationWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.buf.Write(b)
+}
+
+// Flush is a no-op, the response is only stored once it is complete
+func (w *revalidationWriter) Flush() {}
@@ -0,0 +1,92 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaleDirectives(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		wantRequest  string
+		wantResponse string
+		stale        time.Duration
+	}{
+		{name: "no cache control", stale: time.Minute, wantRequest: "max-stale=60"},
+		{name: "existing cache control", cacheControl: "max-age=60", stale: time.Minute, wantRequest: "max-age=60, max-stale=60", wantResponse: "max-age=60, stale-while-revalidate=60"},
+		{name: "rounded up to a second", cacheControl: "max-age=60", stale: time.Millisecond, wantRequest: "max-age=60, max-stale=1", wantResponse: "max-age=60, stale-while-revalidate=1"},
+		{name: "already set", cacheControl: "max-stale=5, stale-while-revalidate=5", stale: time.Minute, wantRequest: "max-stale=5, stale-while-revalidate=5", wantResponse: "max-stale=5, stale-while-revalidate=5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil)
+			h := make(http.Header)
+			if tt.cacheControl != "" {
+				r.Header.Set("Cache-Control", tt.cacheControl)
+				h.Set("Cache-Control", tt.cacheControl)
+			}
+			acceptStale(r, tt.stale)
+			if got := r.Header.Get("Cache-Control"); got != tt.wantRequest {
+				t.Errorf("got request Cache-Control %q, want %q", got, tt.wantRequest)
+			}
+			// a response without a Cache-Control gets the default, which
+			// has the directive added when the cache is configured
+			allowStale(h, tt.stale)
+			if got := h.Get("Cache-Control"); got != tt.wantResponse {
+				t.Errorf("got response Cache-Control %q, want %q", got, tt.wantResponse)
+			}
+		})
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	// every retrieval gets a new body, which is fresh for a second
+	var calls atomic.Int64
+	retrieve := func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+		w.Header().Set("Cache-Control", "public, max-age=1")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "car %d", n)
+	}
+	srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir(), EmitXCache: true, StaleWhileRevalidate: time.Minute}, retrieve)
+
+	// request returns the body of a response, checking it is served from
+	// the cache once the first request has filled it
+	request := func(wantXCache string) string {
+		t.Helper()
+		res, body := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusOK)
+		}
+		if got := res.Header.Get("X-Cache"); got != wantXCache {
+			t.Errorf("got X-Cache %q, want %q", got, wantXCache)
+		}
+		return body
+	}
+	if body := request(cacheMiss); body != "car 1" {
+		t.Fatalf("got body %q, want %q", body, "car 1")
+	}
+
+	// the expired entry is served while it is refreshed, rather than
+	// waiting on a retrieval
+	time.Sleep(2 * time.Second)
+	if body := request(cacheHit); body != "car 1" {
+		t.Fatalf("got body %q for the expired entry, want %q", body, "car 1")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for request(cacheHit) == "car 1" {
+		if time.Now().After(deadline) {
+			t.Fatal("the expired entry was never refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := calls.Load(); got < 2 {
+		t.Errorf("got %d retrievals, want the entry to have been refreshed", got)
+	}
+}
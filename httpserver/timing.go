@@ -0,0 +1,158 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+// timingBreakdownTrailer is the trailer the timing breakdown is sent in
+const timingBreakdownTrailer = "X-Timing-Breakdown"
+
+// timingBreakdownReport is the JSON timing breakdown of a request. Discovery
+// and dial are only reported for retrievals that reached those phases, which
+// cache hits never do.
+type timingBreakdownReport struct {
+	DiscoveryMs *float64 `json:"discoveryMs,omitempty"`
+	DialMs      *float64 `json:"dialMs,omitempty"`
+	TTFBMs      float64  `json:"ttfbMs"`
+	TransferMs  float64  `json:"transferMs"`
+	TotalMs     float64  `json:"totalMs"`
+	Cache       string   `json:"cache"`
+}
+
+// timingBreakdown sends a JSON breakdown of the time spent in each phase of
+// a request in a trailer, for requests with ?timing=true bearing the admin
+// token. Discovery and dial times come from the Server-Timing metrics lassie
+// records for the retrieval, the rest is measured here. The timing parameter
+// is removed before the request goes any further, so it doesn't split cache
+// entries.
+func timingBreakdown(adminToken string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !query.Has("timing") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		enabled := query.Get("timing") == "true"
+		query.Del("timing")
+		r.URL.RawQuery = query.Encode()
+		r.RequestURI = r.URL.RequestURI()
+		if !enabled || !authorized(r, adminToken) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// lassie only records its metrics when there is a Server-Timing
+		// header to record them in
+		timing := servertiming.FromContext(r.Context())
+		if timing == nil {
+			timing = &servertiming.Header{}
+			r = r.WithContext(servertiming.NewContext(r.Context(), timing))
+		}
+		tw := &timingWriter{ResponseWriter: w, start: time.Now()}
+		next.ServeHTTP(tw, r)
+		if !tw.wroteHeader {
+			return
+		}
+
+		report := timingBreakdownReport{
+			TTFBMs:     milliseconds(tw.firstByte.Sub(tw.start)),
+			TransferMs: milliseconds(time.Since(tw.firstByte)),
+			TotalMs:    milliseconds(time.Since(tw.start)),
+			Cache:      tw.cache,
+		}
+		report.DiscoveryMs, report.DialMs = retrievalPhases(timing)
+		breakdown, err := json.Marshal(report)
+		if err != nil {
+			logger.Warnw("failed to encode timing breakdown", "err", err)
+			return
+		}
+		w.Header().Set(timingBreakdownTrailer, string(breakdown))
+	})
+}
+
+// retrievalPhases returns the discovery and dial times from the metrics
+// lassie records. Dial is taken from the retrieval that received the first
+// byte, or the first one to connect if none did.
+func retrievalPhases(timing *servertiming.Header) (discovery *float64, dial *float64) {
+	timing.Lock()
+	defer timing.Unlock()
+
+	var connected *servertiming.Metric
+	for _, metric := range timing.Metrics {
+		switch {
+		case metric.Name == string(types.StartedFindingCandidatesCode):
+			ms := milliseconds(metric.Duration)
+			discovery = &ms
+		case strings.HasPrefix(metric.Name, "retrieval-"):
+			if _, ok := metric.Extra[string(types.ConnectedToProviderCode)]; !ok {
+				continue
+			}
+			if connected == nil {
+				connected = metric
+			}
+			if _, ok := metric.Extra[string(types.FirstByteCode)]; ok {
+				connected = metric
+			}
+		}
+	}
+	if connected != nil {
+		// lassie records the time since the retrieval started in
+		// nanoseconds
+		if ns, err := strconv.ParseInt(connected.Extra[string(types.ConnectedToProviderCode)], 10, 64); err == nil {
+			ms := milliseconds(time.Duration(ns))
+			dial = &ms
+		}
+	}
+	return discovery, dial
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// timingWriter records when the first byte of a response is written and the
+// cache result, and declares the timing breakdown trailer
+type timingWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	firstByte   time.Time
+	cache       string
+	wroteHeader bool
+}
+
+func (w *timingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.firstByte = time.Now()
+		w.cache = cacheResult(w.Header().Get("Cache-Status"))
+		// trailers can only follow a chunked body
+		w.Header().Del("Content-Length")
+		w.Header().Add("Trailer", timingBreakdownTrailer)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
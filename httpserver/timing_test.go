@@ -0,0 +1,185 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	servertiming "github.com/mitchellh/go-server-timing"
+)
+
+// lassieTimedRetrieval records Server-Timing metrics as lassie does for a
+// retrieval that found candidates in 20ms, failed on one provider after
+// connecting to it and received the first byte from another that connected
+// after 8ms
+func lassieTimedRetrieval(query *string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*query = r.URL.RawQuery
+		timing := servertiming.FromContext(r.Context())
+		if timing != nil {
+			discovery := timing.NewMetric("started-finding-candidates")
+			discovery.Duration = 20 * time.Millisecond
+			failed := timing.NewMetric("retrieval-a")
+			failed.Extra = map[string]string{"connected-to-provider": "3000000", "failed-retrieval": "4000000"}
+			served := timing.NewMetric("retrieval-b")
+			served.Extra = map[string]string{"connected-to-provider": "8000000", "first-byte-received": "9000000"}
+		}
+		w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+		_, _ = io.WriteString(w, "car")
+	}
+}
+
+func TestTimingBreakdown(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverTiming string
+		query        string
+		token        string
+		wantTrailer  bool
+	}{
+		{name: "requested", serverTiming: "on", query: "?timing=true", token: "secret", wantTrailer: true},
+		{name: "requested without server timing", serverTiming: "off", query: "?timing=true", token: "secret", wantTrailer: true},
+		{name: "requested with other params", serverTiming: "on", query: "?dag-scope=entity&timing=true", token: "secret", wantTrailer: true},
+		{name: "missing token", serverTiming: "on", query: "?timing=true"},
+		{name: "wrong token", serverTiming: "on", query: "?timing=true", token: "guess"},
+		{name: "not requested", serverTiming: "on", token: "secret"},
+		{name: "turned off", serverTiming: "on", query: "?timing=false", token: "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			srv := startTestServer(t, HttpServerConfig{DisableCache: true, AdminToken: "secret", ServerTiming: tt.serverTiming, TimingBreakdown: true}, lassieTimedRetrieval(&query))
+			header := http.Header{}
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+			res, body := get(t, srv, http.MethodGet, "/ipfs/"+testRoot+tt.query, header)
+			if res.StatusCode != http.StatusOK || body != "car" {
+				t.Fatalf("got status %d with body %q, want %d with %q", res.StatusCode, body, http.StatusOK, "car")
+			}
+			if strings.Contains(query, "timing") {
+				t.Errorf("timing parameter reached the retrieval in %q", query)
+			}
+
+			trailer := res.Trailer.Get(timingBreakdownTrailer)
+			if !tt.wantTrailer {
+				if trailer != "" {
+					t.Errorf("got timing breakdown %s, want none", trailer)
+				}
+				return
+			}
+			var got timingBreakdownReport
+			if err := json.Unmarshal([]byte(trailer), &got); err != nil {
+				t.Fatalf("failed to decode timing breakdown %q: %v", trailer, err)
+			}
+			if got.DiscoveryMs == nil || *got.DiscoveryMs != 20 {
+				t.Errorf("got discovery %v, want 20ms", got.DiscoveryMs)
+			}
+			if got.DialMs == nil || *got.DialMs != 8 {
+				t.Errorf("got dial %v, want 8ms from the retrieval that received the first byte", got.DialMs)
+			}
+			if got.TTFBMs < 0 || got.TransferMs < 0 || got.TotalMs < got.TTFBMs+got.TransferMs-1 {
+				t.Errorf("got ttfb %vms and transfer %vms adding up to more than the %vms total", got.TTFBMs, got.TransferMs, got.TotalMs)
+			}
+			if got.Cache != cacheBypass {
+				t.Errorf("got cache %q, want %q", got.Cache, cacheBypass)
+			}
+		})
+	}
+}
+
+func TestRetrievalPhases(t *testing.T) {
+	tests := []struct {
+		name          string
+		metrics       []*servertiming.Metric
+		wantDiscovery float64
+		wantDial      float64
+	}{
+		{name: "cache hit"},
+		{
+			name:          "no candidates",
+			metrics:       []*servertiming.Metric{{Name: "started-finding-candidates", Duration: 15 * time.Millisecond}},
+			wantDiscovery: 15,
+		},
+		{
+			name: "first connection",
+			metrics: []*servertiming.Metric{
+				{Name: "started-finding-candidates", Duration: 15 * time.Millisecond},
+				{Name: "retrieval-a", Extra: map[string]string{"connected-to-provider": "2500000"}},
+				{Name: "retrieval-b", Extra: map[string]string{"connected-to-provider": "4000000"}},
+			},
+			wantDiscovery: 15,
+			wantDial:      2.5,
+		},
+		{
+			name: "connection that received the first byte",
+			metrics: []*servertiming.Metric{
+				{Name: "started-finding-candidates", Duration: 15 * time.Millisecond},
+				{Name: "retrieval-a", Extra: map[string]string{"connected-to-provider": "2500000"}},
+				{Name: "retrieval-b", Extra: map[string]string{"connected-to-provider": "4000000", "first-byte-received": "6000000"}},
+			},
+			wantDiscovery: 15,
+			wantDial:      4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discovery, dial := retrievalPhases(&servertiming.Header{Metrics: tt.metrics})
+			if got := derefMs(discovery); got != tt.wantDiscovery {
+				t.Errorf("got discovery %vms, want %vms", got, tt.wantDiscovery)
+			}
+			if got := derefMs(dial); got != tt.wantDial {
+				t.Errorf("got dial %vms, want %vms", got, tt.wantDial)
+			}
+		})
+	}
+}
+
+// derefMs returns the milliseconds, or zero for a phase that wasn't reached
+func derefMs(ms *float64) float64 {
+	if ms == nil {
+		return 0
+	}
+	return *ms
+}
+
+func TestServerTiming(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverTiming string
+		token        string
+		wantHeader   bool
+	}{
+		{name: "on by default", wantHeader: true},
+		{name: "on", serverTiming: "on", wantHeader: true},
+		{name: "off", serverTiming: "off"},
+		{name: "off with admin token", serverTiming: "off", token: "secret"},
+		{name: "debug with admin token", serverTiming: "debug", token: "secret", wantHeader: true},
+		{name: "debug without token", serverTiming: "debug"},
+		{name: "debug with wrong token", serverTiming: "debug", token: "guess"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			srv := startTestServer(t, HttpServerConfig{DisableCache: true, AdminToken: "secret", ServerTiming: tt.serverTiming}, lassieTimedRetrieval(&query))
+			header := http.Header{}
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+			res, body := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, header)
+			if res.StatusCode != http.StatusOK || body != "car" {
+				t.Fatalf("got status %d with body %q, want %d with %q", res.StatusCode, body, http.StatusOK, "car")
+			}
+			got := res.Header.Get("Server-Timing")
+			if tt.wantHeader && !strings.Contains(got, "started-finding-candidates") {
+				t.Errorf("got Server-Timing %q, want lassie's metrics", got)
+			}
+			if !tt.wantHeader && got != "" {
+				t.Errorf("got Server-Timing %q, want none", got)
+			}
+		})
+	}
+}
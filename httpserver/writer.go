@@ -0,0 +1,185 @@
+package httpserver
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// trackingWriter records the status code and number of body bytes written to
+// a response. It passes flushes through so streamed responses still stream.
+type trackingWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *trackingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *trackingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// wroteHeader reports whether the response status has been sent
+func (w *trackingWriter) wroteHeader() bool {
+	return w.status != 0
+}
+
+// bufferedFlushWriter gives a response writer that doesn't implement
+// http.Flusher a Flush method, buffering writes and passing them on in chunks
+// whenever the handler flushes.
+type bufferedFlushWriter struct {
+	http.ResponseWriter
+	buf *bufio.Writer
+}
+
+func (w *bufferedFlushWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *bufferedFlushWriter) Flush() {
+	if err := w.buf.Flush(); err != nil {
+		logger.Debugw("failed to flush buffered response", "err", err)
+	}
+}
+
+var warnNotFlusher sync.Once
+
+// ensureFlusher wraps the response writer in a bufferedFlushWriter if a
+// middleware in the chain has hidden its http.Flusher, so that handlers
+// which stream and flush keep working
+func ensureFlusher(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); ok {
+			next(w, r)
+			return
+		}
+
+		warnNotFlusher.Do(func() {
+			logger.Warnw("response writer does not support flushing, buffering streamed responses", "writer", fmt.Sprintf("%T", w))
+		})
+		bw := &bufferedFlushWriter{ResponseWriter: w, buf: bufio.NewWriter(w)}
+		next(bw, r)
+		bw.Flush()
+	}
+}
+
+// intervalFlushWriter flushes written data to the client at least once per
+// interval while a response is streaming. Writes and flushes are serialized
+// so that flushes from the timer only ever happen between complete writes.
+type intervalFlushWriter struct {
+	http.ResponseWriter
+	flusher http.Flusher
+	lk      sync.Mutex
+	dirty   bool
+}
+
+func (w *intervalFlushWriter) Write(b []byte) (int, error) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	w.dirty = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *intervalFlushWriter) Flush() {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	w.dirty = false
+	w.flusher.Flush()
+}
+
+func (w *intervalFlushWriter) flushIfDirty() {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	if w.dirty {
+		w.dirty = false
+		w.flusher.Flush()
+	}
+}
+
+// flushEvery makes sure data written by a streaming handler reaches the
+// client at least once per interval, so slow retrievals don't look idle to
+// clients and intermediaries
+func flushEvery(interval time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if interval <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		fw := &intervalFlushWriter{ResponseWriter: w, flusher: flusher}
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					fw.flushIfDirty()
+				}
+			}
+		}()
+
+		next(fw, r)
+		close(done)
+		<-stopped
+	}
+}
+
+// serverErrorWriter sends server error responses to a cache miss straight
+// to the client. The cache neither stores nor sends them, which would
+// otherwise leave the client with an empty response.
+type serverErrorWriter struct {
+	// ResponseWriter is the cache's writer for the miss
+	http.ResponseWriter
+	client http.ResponseWriter
+	direct bool
+}
+
+func (w *serverErrorWriter) WriteHeader(status int) {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		w.direct = true
+		w.client.WriteHeader(status)
+	default:
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *serverErrorWriter) Write(b []byte) (int, error) {
+	if w.direct {
+		return w.client.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
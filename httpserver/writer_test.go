@@ -0,0 +1,107 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nonFlushingWriter hides the http.Flusher of the writer it wraps, as some
+// middleware does
+type nonFlushingWriter struct {
+	http.ResponseWriter
+}
+
+func TestEnsureFlusher(t *testing.T) {
+	t.Run("flusher passes through", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		ensureFlusher(func(w http.ResponseWriter, r *http.Request) {
+			if w != rec {
+				t.Errorf("got writer %T, want the flusher unwrapped", w)
+			}
+		})(rec, httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil))
+	})
+
+	t.Run("non-flusher is buffered", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		ensureFlusher(func(w http.ResponseWriter, r *http.Request) {
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				t.Fatalf("got writer %T, want an http.Flusher", w)
+			}
+			_, _ = io.WriteString(w, "first")
+			if got := rec.Body.String(); got != "" {
+				t.Errorf("got %q before flushing, want the write buffered", got)
+			}
+			flusher.Flush()
+			if got := rec.Body.String(); got != "first" {
+				t.Errorf("got %q after flushing, want %q", got, "first")
+			}
+			_, _ = io.WriteString(w, " second")
+		})(nonFlushingWriter{rec}, httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil))
+
+		// whatever is left buffered is sent once the handler returns
+		if got := rec.Body.String(); got != "first second" {
+			t.Errorf("got %q after the handler returned, want %q", got, "first second")
+		}
+	})
+}
+
+// flushCountingWriter counts the flushes that reach the client
+type flushCountingWriter struct {
+	*httptest.ResponseRecorder
+	flushes atomic.Int64
+}
+
+func (w *flushCountingWriter) Flush() {
+	w.flushes.Add(1)
+	w.ResponseRecorder.Flush()
+}
+
+func TestFlushEvery(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	tests := []struct {
+		name     string
+		interval time.Duration
+		// wantFlushed is whether each block is flushed before the next is
+		// retrieved
+		wantFlushed bool
+	}{
+		{name: "periodic flushes", interval: interval, wantFlushed: true},
+		{name: "disabled", interval: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &flushCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+			// slowBlocks stands in for a retrieval whose blocks arrive well
+			// apart, which never flushes itself
+			slowBlocks := func(rw http.ResponseWriter, _ *http.Request) {
+				for i := int64(0); i < 3; i++ {
+					_, _ = io.WriteString(rw, "block")
+					time.Sleep(10 * interval)
+					want := int64(0)
+					if tt.wantFlushed {
+						want = i + 1
+					}
+					if got := w.flushes.Load(); got != want {
+						t.Errorf("got %d flushes after block %d, want %d", got, i+1, want)
+					}
+				}
+				// nothing is written while idle, so nothing is flushed
+				idleFlushes := w.flushes.Load()
+				time.Sleep(5 * interval)
+				if got := w.flushes.Load(); got != idleFlushes {
+					t.Errorf("got %d flushes while idle, want none", got-idleFlushes)
+				}
+			}
+			flushEvery(tt.interval, slowBlocks)(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+testRoot, nil))
+
+			if got := w.Body.String(); got != "blockblockblock" {
+				t.Errorf("got body %q, want %q", got, "blockblockblock")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	cacheHit    = "HIT"
+	cacheMiss   = "MISS"
+	cacheBypass = "BYPASS"
+)
+
+// cacheResult classifies a response from the Cache-Status header set by the
+// Souin middleware. Responses Souin forwarded on a miss are reported as a
+// miss whether or not it stored them, such as when the admission filter or
+// low disk space refuses to. Responses it forwarded without looking in the
+// cache, or that never went through it, are reported as a bypass.
+func cacheResult(cacheStatus string) string {
+	switch {
+	case strings.Contains(cacheStatus, "; hit"):
+		return cacheHit
+	case strings.Contains(cacheStatus, "fwd=uri-miss"):
+		return cacheMiss
+	default:
+		return cacheBypass
+	}
+}
+
+// cacheResultWriter records the cache result of a response, based on the
+// Cache-Status header present when the response status is written, and
+// optionally reports it to the client in an X-Cache header
+type cacheResultWriter struct {
+	http.ResponseWriter
+	emitHeader bool
+	result     string
+	// stale is set once an expired cache entry has been sent, which the
+	// cache then refreshes in the background
+	stale bool
+}
+
+// Header returns the response headers. Once an expired entry has been sent,
+// the cache's background refresh still sets headers through this writer,
+// which would race with the server finishing the response, so they go to a
+// map of their own.
+func (w *cacheResultWriter) Header() http.Header {
+	if w.stale {
+		return make(http.Header)
+	}
+	return w.ResponseWriter.Header()
+}
+
+func (w *cacheResultWriter) WriteHeader(status int) {
+	if w.result == "" {
+		cacheStatus := w.Header().Get("Cache-Status")
+		w.result = cacheResult(cacheStatus)
+		if w.emitHeader {
+			w.Header().Set("X-Cache", w.result)
+		}
+		w.stale = strings.Contains(cacheStatus, "fwd=stale")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheResultWriter) Write(b []byte) (int, error) {
+	if w.result == "" {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheResultWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *cacheResultWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,56 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCacheResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		cacheStatus string
+		want        string
+	}{
+		{name: "hit", cacheStatus: "Saturn; hit; ttl=31535999; key=", want: cacheHit},
+		{name: "stored miss", cacheStatus: "Saturn; fwd=uri-miss; stored; key=", want: cacheMiss},
+		{name: "miss refused storage", cacheStatus: "Saturn; fwd=uri-miss; detail=NO-STORE-DIRECTIVE; key=", want: cacheMiss},
+		{name: "uncacheable miss", cacheStatus: "Saturn; fwd=uri-miss; key=; detail=UNCACHEABLE-STATUS-CODE", want: cacheMiss},
+		{name: "bypass", cacheStatus: "Saturn; fwd=bypass; detail=UNSUPPORTED-METHOD", want: cacheBypass},
+		{name: "not through the cache", cacheStatus: "", want: cacheBypass},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cacheResult(tt.cacheStatus); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXCache(t *testing.T) {
+	tests := []struct {
+		name           string
+		cacheAdmission string
+		// want is the X-Cache of successive requests for the same content
+		want []string
+	}{
+		{name: "always", cacheAdmission: "always", want: []string{cacheMiss, cacheHit, cacheHit}},
+		{name: "second hit", cacheAdmission: "second-hit", want: []string{cacheMiss, cacheMiss, cacheHit}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retrieval := &stubRetrieval{body: "car"}
+			srv := startTestServer(t, HttpServerConfig{CacheDir: t.TempDir(), EmitXCache: true, CacheAdmission: tt.cacheAdmission}, retrieval.retrieve)
+
+			for i, want := range tt.want {
+				res, body := get(t, srv, http.MethodGet, "/ipfs/"+testRoot, nil)
+				if res.StatusCode != http.StatusOK || body != "car" {
+					t.Fatalf("request %d got status %d with body %q, want %d with %q", i+1, res.StatusCode, body, http.StatusOK, "car")
+				}
+				if got := res.Header.Get("X-Cache"); got != want {
+					t.Errorf("request %d got X-Cache %q, want %q", i+1, got, want)
+				}
+			}
+		})
+	}
+}
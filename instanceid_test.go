@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEventRecorderInstanceID(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip("no hostname to fall back to:", err)
+	}
+	tests := []struct {
+		name string
+		id   string
+		seed string
+		// sameAs is the seed the ID is expected to be derived from
+		sameAs string
+		want   string
+	}{
+		{name: "explicit ID wins", id: "my-node", seed: "seed", want: "my-node"},
+		{name: "derived from the seed", seed: "node-1.example.com", sameAs: "node-1.example.com"},
+		{name: "hostname fallback", sameAs: hostname},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eventRecorderInstanceID(tt.id, tt.seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == "" {
+				want = uuid.NewSHA1(uuid.NameSpaceDNS, []byte(tt.sameAs)).String()
+			}
+			if got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDeriveInstanceID(t *testing.T) {
+	first, err := deriveInstanceID("node-1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := uuid.Parse(first)
+	if err != nil {
+		t.Fatalf("got %q, want a UUID: %v", first, err)
+	}
+	if id.Version() != 5 {
+		t.Errorf("got UUID version %d, want 5", id.Version())
+	}
+
+	// restarts with the same seed report as the same instance
+	again, err := deriveInstanceID("node-1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Errorf("got %q deriving from the same seed again, want %q", again, first)
+	}
+	other, err := deriveInstanceID("node-2.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Errorf("got the same ID %q for different seeds", first)
+	}
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"mime"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/filecoin-saturn/cassiopeia/httpserver"
 	"github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
@@ -41,11 +45,14 @@ func main() {
 	}()
 
 	app := &cli.App{
-		Name:    "cassiopeia",
-		Usage:   "Utility for retrieving content from the Filecoin network",
-		Suggest: true,
-		Flags:   daemonFlags,
-		Action:  serveAction,
+		Name:     "cassiopeia",
+		Usage:    "Utility for retrieving content from the Filecoin network",
+		Version:  readBuildInfo().version,
+		Suggest:  true,
+		Flags:    daemonFlags,
+		Before:   loadConfigFile,
+		Action:   serveAction,
+		Commands: []*cli.Command{versionCommand},
 	}
 
 	if err := app.RunContext(ctx, os.Args); err != nil {
@@ -54,14 +61,22 @@ func main() {
 }
 
 var daemonFlags = []cli.Flag{
+	FlagConfig,
 	&cli.StringFlag{
 		Name:        "address",
 		Aliases:     []string{"a"},
-		Usage:       "the address the http server listens on",
+		Usage:       "the address the http server listens on, or unix:/path/to/socket to listen on a unix domain socket",
 		Value:       "127.0.0.1",
 		DefaultText: "127.0.0.1",
 		EnvVars:     []string{"LASSIE_ADDRESS"},
 	},
+	&cli.StringFlag{
+		Name:        "listen-network",
+		Usage:       "the network the http server listens on, one of tcp, tcp4 or tcp6",
+		Value:       "tcp",
+		DefaultText: "tcp (dual-stack)",
+		EnvVars:     []string{"LASSIE_LISTEN_NETWORK"},
+	},
 	&cli.UintFlag{
 		Name:        "port",
 		Aliases:     []string{"p"},
@@ -70,6 +85,57 @@ var daemonFlags = []cli.Flag{
 		DefaultText: "random",
 		EnvVars:     []string{"LASSIE_PORT"},
 	},
+	&cli.StringFlag{
+		Name:        "tls-cert",
+		Usage:       "the TLS certificate file to serve https with, requires --tls-key",
+		DefaultText: "serve plain http",
+		EnvVars:     []string{"LASSIE_TLS_CERT"},
+	},
+	&cli.StringFlag{
+		Name:        "tls-key",
+		Usage:       "the TLS private key file to serve https with, requires --tls-cert",
+		DefaultText: "serve plain http",
+		EnvVars:     []string{"LASSIE_TLS_KEY"},
+	},
+	&cli.UintFlag{
+		Name:        "max-conns-per-ip",
+		Usage:       "maximum number of simultaneous connections from a single client IP",
+		Value:       0,
+		DefaultText: "no limit",
+		EnvVars:     []string{"LASSIE_MAX_CONNS_PER_IP"},
+	},
+	&cli.BoolFlag{
+		Name:    "proxy-protocol",
+		Usage:   "decode PROXY protocol v1 and v2 headers from trusted upstreams to see the real client address",
+		EnvVars: []string{"LASSIE_PROXY_PROTOCOL"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "proxy-protocol-trusted",
+		Usage:   "IPs or CIDRs of upstreams trusted to send PROXY protocol headers, required with --proxy-protocol",
+		EnvVars: []string{"LASSIE_PROXY_PROTOCOL_TRUSTED"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "allow-ips",
+		Usage:   "IPs or CIDRs of the only clients allowed to make requests, seperated by a comma",
+		EnvVars: []string{"LASSIE_ALLOW_IPS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "deny-ips",
+		Usage:   "IPs or CIDRs of clients refused with a 403, seperated by a comma",
+		EnvVars: []string{"LASSIE_DENY_IPS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "trusted-proxies",
+		Usage:   "IPs or CIDRs of proxies trusted to give the client IP in X-Forwarded-For, for --allow-ips and --deny-ips",
+		EnvVars: []string{"LASSIE_TRUSTED_PROXIES"},
+	},
+	&cli.Uint64Flag{
+		Name:        "max-request-metadata-bytes",
+		Usage:       "maximum combined size in bytes of a request's URL and headers",
+		Value:       0,
+		DefaultText: "no limit",
+		EnvVars:     []string{"LASSIE_MAX_REQUEST_METADATA_BYTES"},
+	},
 	&cli.Uint64Flag{
 		Name:        "maxblocks",
 		Aliases:     []string{"mb"},
@@ -78,6 +144,19 @@ var daemonFlags = []cli.Flag{
 		DefaultText: "no limit",
 		EnvVars:     []string{"LASSIE_MAX_BLOCKS_PER_REQUEST"},
 	},
+	&cli.DurationFlag{
+		Name:        "flush-interval",
+		Usage:       "flush streamed response data to the client at least this often; only applies to responses that bypass the cache, such as with --disable-cache or for HEAD and Range requests",
+		Value:       0,
+		DefaultText: "only when the handler flushes",
+		EnvVars:     []string{"LASSIE_FLUSH_INTERVAL"},
+	},
+	&cli.DurationFlag{
+		Name:    "shutdown-timeout",
+		Usage:   "how long to wait for in-flight requests on shutdown before closing their connections, 0 waits indefinitely",
+		Value:   defaultShutdownTimeout,
+		EnvVars: []string{"LASSIE_SHUTDOWN_TIMEOUT"},
+	},
 	&cli.IntFlag{
 		Name:        "libp2p-conns-lowwater",
 		Aliases:     []string{"lw"},
@@ -94,6 +173,42 @@ var daemonFlags = []cli.Flag{
 		DefaultText: "libp2p default",
 		EnvVars:     []string{"LASSIE_LIBP2P_CONNECTIONS_HIGHWATER"},
 	},
+	&cli.UintFlag{
+		Name:    "host-init-retries",
+		Usage:   "number of times to retry creating the libp2p host on startup, with backoff, before giving up",
+		Value:   0,
+		EnvVars: []string{"LASSIE_HOST_INIT_RETRIES"},
+	},
+	&cli.UintFlag{
+		Name:        "max-concurrent-requests",
+		Usage:       "max number of simultaneous retrievals, requests served from the cache are not counted",
+		Value:       0,
+		DefaultText: "no limit",
+		EnvVars:     []string{"LASSIE_MAX_CONCURRENT_REQUESTS"},
+	},
+	&cli.UintFlag{
+		Name:        "max-concurrent-all",
+		Usage:       "max number of simultaneous dag-scope=all retrievals",
+		Value:       0,
+		DefaultText: "no limit",
+		EnvVars:     []string{"LASSIE_MAX_CONCURRENT_ALL"},
+	},
+	&cli.UintFlag{
+		Name:        "max-concurrent-entity",
+		Usage:       "max number of simultaneous dag-scope=entity retrievals",
+		Value:       0,
+		DefaultText: "no limit",
+		EnvVars:     []string{"LASSIE_MAX_CONCURRENT_ENTITY"},
+	},
+	&cli.UintFlag{
+		Name:        "max-concurrent-block",
+		Usage:       "max number of simultaneous dag-scope=block retrievals",
+		Value:       0,
+		DefaultText: "no limit",
+		EnvVars:     []string{"LASSIE_MAX_CONCURRENT_BLOCK"},
+	},
+	FlagAccessToken,
+	FlagAdminToken,
 	&cli.UintFlag{
 		Name:        "concurrent-sp-retrievals",
 		Aliases:     []string{"cr"},
@@ -104,21 +219,55 @@ var daemonFlags = []cli.Flag{
 	},
 	FlagEventRecorderAuth,
 	FlagEventRecorderInstanceId,
+	FlagEventRecorderInstanceSeed,
 	FlagEventRecorderUrl,
+	FlagEventRecorderBufferSize,
+	FlagEventRecorderDropPolicy,
 	FlagVerbose,
 	FlagVeryVerbose,
 	FlagProtocols,
 	FlagAllowProviders,
 	FlagExcludeProviders,
 	FlagTempDir,
+	FlagCacheDir,
 	FlagBitswapConcurrency,
 	FlagGlobalTimeout,
 	FlagProviderTimeout,
+	FlagDisableCache,
+	FlagCacheBackend,
+	FlagRedisAddr,
+	FlagRedisPassword,
+	FlagCacheOpenRetries,
+	FlagCacheControl,
+	FlagCacheMinFreeDisk,
+	FlagCacheGCInterval,
+	FlagStaleWhileRevalidate,
+	FlagCacheAdmission,
+	FlagNormalizePaths,
+	FlagProviderLatencyWindow,
+	FlagLegacyParams,
+	FlagAllowedAccept,
+	FlagDuplicates,
+	FlagServerTiming,
+	FlagTimingBreakdown,
+	FlagEmitXCache,
+	FlagCompress,
+	FlagEmitServedBy,
+	FlagHTTP10Mode,
+	FlagHTTP10MaxBuffer,
+	FlagMetricsPath,
+	FlagEnablePprof,
+	FlagAccessLogFormat,
+	FlagInjectLatency,
 }
 
 const (
-	defaultBitswapConcurrency int           = 6                // 6 concurrent requests
-	defaultProviderTimeout    time.Duration = 20 * time.Second // 20 seconds
+	defaultBitswapConcurrency      int           = 6                // 6 concurrent requests
+	defaultProviderTimeout         time.Duration = 20 * time.Second // 20 seconds
+	defaultCacheOpenRetries        uint          = 3                // 3 retries
+	defaultEventRecorderBufferSize int           = 4096             // 4096 events
+	defaultShutdownTimeout         time.Duration = 30 * time.Second // 30 seconds
+	defaultCacheGCInterval         time.Duration = 10 * time.Minute // 10 minutes
 )
 
 var (
@@ -182,10 +331,19 @@ var FlagEventRecorderAuth = &cli.StringFlag{
 var FlagEventRecorderInstanceId = &cli.StringFlag{
 	Name:        "event-recorder-instance-id",
 	Usage:       "the instance ID to use for an event recorder API request",
-	DefaultText: "a random v4 uuid",
+	DefaultText: "a uuid derived from the instance seed",
 	EnvVars:     []string{"LASSIE_EVENT_RECORDER_INSTANCE_ID"},
 }
 
+// FlagEventRecorderInstanceSeed provides the seed from which a stable instance
+// ID is derived when no explicit instance ID is set.
+var FlagEventRecorderInstanceSeed = &cli.StringFlag{
+	Name:        "event-recorder-instance-seed",
+	Usage:       "the seed used to derive a stable event recorder instance ID when one isn't set",
+	DefaultText: "the hostname",
+	EnvVars:     []string{"LASSIE_EVENT_RECORDER_INSTANCE_SEED"},
+}
+
 // FlagEventRecorderUrl asks for and provides the URL for an event recorder API
 // to send metrics to.
 var FlagEventRecorderUrl = &cli.StringFlag{
@@ -195,6 +353,41 @@ var FlagEventRecorderUrl = &cli.StringFlag{
 	EnvVars:     []string{"LASSIE_EVENT_RECORDER_URL"},
 }
 
+// FlagEventRecorderBufferSize sets how many retrieval events are queued for
+// the event recorder before events start being dropped.
+var FlagEventRecorderBufferSize = &cli.IntFlag{
+	Name:    "event-recorder-buffer-size",
+	Usage:   "the number of retrieval events to queue for an event recorder API before dropping events",
+	Value:   defaultEventRecorderBufferSize,
+	EnvVars: []string{"LASSIE_EVENT_RECORDER_BUFFER_SIZE"},
+}
+
+// FlagEventRecorderDropPolicy chooses which events are dropped when the event
+// recorder queue is full.
+var FlagEventRecorderDropPolicy = &cli.StringFlag{
+	Name:    "event-recorder-drop-policy",
+	Usage:   "which retrieval events to drop when the event recorder queue is full, oldest or newest",
+	Value:   "oldest",
+	EnvVars: []string{"LASSIE_EVENT_RECORDER_DROP_POLICY"},
+}
+
+// FlagAccessToken sets the bearer token required on every /ipfs/ request.
+var FlagAccessToken = &cli.StringFlag{
+	Name:        "access-token",
+	Usage:       "the bearer token required in the Authorization header of every /ipfs/ request, the --admin-token is also accepted",
+	DefaultText: "retrievals don't require a token",
+	EnvVars:     []string{"LASSIE_ACCESS_TOKEN"},
+}
+
+// FlagAdminToken sets the bearer token protecting the administrative
+// endpoints. Those endpoints are not served at all when it isn't set.
+var FlagAdminToken = &cli.StringFlag{
+	Name:        "admin-token",
+	Usage:       "the bearer token required by administrative endpoints such as /purge/, which are disabled without one",
+	DefaultText: "administrative endpoints are disabled",
+	EnvVars:     []string{"LASSIE_ADMIN_TOKEN"},
+}
+
 var providerBlockList map[peer.ID]bool
 var FlagExcludeProviders = &cli.StringFlag{
 	Name:        "exclude-providers",
@@ -267,6 +460,13 @@ var FlagTempDir = &cli.StringFlag{
 	EnvVars:     []string{"LASSIE_TEMP_DIRECTORY"},
 }
 
+var FlagCacheDir = &cli.StringFlag{
+	Name:        "cache-dir",
+	Usage:       "directory to store the badger cache in, which should be on persistent storage",
+	DefaultText: "the temp directory",
+	EnvVars:     []string{"LASSIE_CACHE_DIR"},
+}
+
 var FlagBitswapConcurrency = &cli.IntFlag{
 	Name:    "bitswap-concurrency",
 	Usage:   "maximum number of concurrent bitswap requests per retrieval",
@@ -289,6 +489,255 @@ var FlagProviderTimeout = &cli.DurationFlag{
 	EnvVars: []string{"LASSIE_PROVIDER_TIMEOUT"},
 }
 
+var FlagDisableCache = &cli.BoolFlag{
+	Name:    "disable-cache",
+	Usage:   "serve every request straight from lassie, without caching, for debugging and benchmarking",
+	EnvVars: []string{"LASSIE_DISABLE_CACHE"},
+}
+
+var FlagCacheBackend = &cli.StringFlag{
+	Name:    "cache-backend",
+	Usage:   "the cache storage to use, badger for a local cache in --cache-dir or redis for a cache shared between instances",
+	Value:   "badger",
+	EnvVars: []string{"LASSIE_CACHE_BACKEND"},
+}
+
+var FlagRedisAddr = &cli.StringFlag{
+	Name:    "redis-addr",
+	Usage:   "the host:port of the redis server used by the redis cache backend",
+	EnvVars: []string{"LASSIE_REDIS_ADDR"},
+}
+
+var FlagRedisPassword = &cli.StringFlag{
+	Name:        "redis-password",
+	Usage:       "the password for the redis server used by the redis cache backend",
+	DefaultText: "no password",
+	EnvVars:     []string{"LASSIE_REDIS_PASSWORD"},
+}
+
+var FlagCacheOpenRetries = &cli.UintFlag{
+	Name:    "cache-open-retries",
+	Usage:   "number of times to retry opening the cache store on startup, with backoff, before giving up",
+	Value:   defaultCacheOpenRetries,
+	EnvVars: []string{"LASSIE_CACHE_OPEN_RETRIES"},
+}
+
+var FlagCacheControl = &cli.StringFlag{
+	Name:    "cache-control",
+	Usage:   "the Cache-Control header applied to responses that don't set their own",
+	Value:   httpserver.DefaultCacheControl,
+	EnvVars: []string{"LASSIE_CACHE_CONTROL"},
+	Action: func(cctx *cli.Context, v string) error {
+		return validateCacheControl(v)
+	},
+}
+
+// cacheControlDirective matches a single Cache-Control directive, a token
+// optionally followed by a token or quoted string argument
+var cacheControlDirective = regexp.MustCompile(`^` + httpToken + `(=(` + httpToken + `|"([^"\\]|\\.)*"))?$`)
+
+const httpToken = "[!#$%&'*+.^_`|~0-9A-Za-z-]+"
+
+// validateCacheControl checks that v is a syntactically plausible
+// Cache-Control header value
+func validateCacheControl(v string) error {
+	if strings.TrimSpace(v) == "" {
+		return errors.New("cache-control must not be empty")
+	}
+	for _, directive := range strings.Split(v, ",") {
+		if !cacheControlDirective.MatchString(strings.TrimSpace(directive)) {
+			return fmt.Errorf("invalid cache-control directive %q", strings.TrimSpace(directive))
+		}
+	}
+	return nil
+}
+
+var FlagCacheMinFreeDisk = &cli.StringFlag{
+	Name:        "cache-min-free-disk",
+	Usage:       "stop caching responses while free space on the cache filesystem is below this size, e.g. 10GB",
+	DefaultText: "no minimum",
+	EnvVars:     []string{"LASSIE_CACHE_MIN_FREE_DISK"},
+}
+
+var FlagCacheGCInterval = &cli.DurationFlag{
+	Name:    "cache-gc-interval",
+	Usage:   "how often to garbage collect the badger cache's value log to reclaim disk space, 0 disables GC",
+	Value:   defaultCacheGCInterval,
+	EnvVars: []string{"LASSIE_CACHE_GC_INTERVAL"},
+}
+
+var FlagStaleWhileRevalidate = &cli.DurationFlag{
+	Name:        "stale-while-revalidate",
+	Usage:       "how long an expired cache entry may still be served while it is refreshed in the background",
+	DefaultText: "expired entries are not served",
+	EnvVars:     []string{"LASSIE_STALE_WHILE_REVALIDATE"},
+}
+
+var FlagCacheAdmission = &cli.StringFlag{
+	Name:    "cache-admission",
+	Usage:   "cache admission policy: always, or second-hit to only cache a response when it is requested a second time",
+	Value:   "always",
+	EnvVars: []string{"LASSIE_CACHE_ADMISSION"},
+}
+
+var FlagNormalizePaths = &cli.BoolFlag{
+	Name:    "normalize-paths",
+	Usage:   "clean /ipfs/ request paths before caching and retrieval, rejecting paths that escape the root CID",
+	EnvVars: []string{"LASSIE_NORMALIZE_PATHS"},
+}
+
+var FlagProviderLatencyWindow = &cli.DurationFlag{
+	Name:        "provider-latency-window",
+	Usage:       "serve provider time-to-first-byte percentiles at /admin/providers/latency, computed over a sliding window of this length; requires --admin-token",
+	DefaultText: "disabled",
+	EnvVars:     []string{"LASSIE_PROVIDER_LATENCY_WINDOW"},
+}
+
+var FlagLegacyParams = &cli.BoolFlag{
+	Name:    "legacy-params",
+	Usage:   "accept deprecated trustless gateway query parameters, car-scope and bytes, in place of dag-scope and entity-bytes",
+	EnvVars: []string{"LASSIE_LEGACY_PARAMS"},
+}
+
+var allowedAccept []string
+var FlagAllowedAccept = &cli.StringFlag{
+	Name:        "allowed-accept",
+	DefaultText: "all media types supported by lassie",
+	Usage:       "List of media types that may be requested, seperated by a comma. Example: application/vnd.ipld.car",
+	EnvVars:     []string{"LASSIE_ALLOWED_ACCEPT"},
+	Action: func(cctx *cli.Context, v string) error {
+		// Do nothing if given an empty string
+		if v == "" {
+			return nil
+		}
+
+		allowedAccept = nil
+		for _, mediaType := range strings.Split(v, ",") {
+			mediaType = strings.TrimSpace(mediaType)
+			if _, _, err := mime.ParseMediaType(mediaType); err != nil {
+				return fmt.Errorf("invalid media type %q: %w", mediaType, err)
+			}
+			allowedAccept = append(allowedAccept, mediaType)
+		}
+		return nil
+	},
+}
+
+var FlagDuplicates = &cli.StringFlag{
+	Name:    "duplicates",
+	Usage:   "policy for duplicate blocks in CAR responses: client to honour the request's dups parameter, allow to always include them, or forbid to never include them",
+	Value:   "client",
+	EnvVars: []string{"LASSIE_DUPLICATES"},
+}
+
+var FlagServerTiming = &cli.StringFlag{
+	Name:    "server-timing",
+	Usage:   "Server-Timing header mode: off, on, or debug to only send it to requests bearing the admin token",
+	Value:   "on",
+	EnvVars: []string{"LASSIE_SERVER_TIMING"},
+}
+
+var FlagTimingBreakdown = &cli.BoolFlag{
+	Name:    "timing-breakdown",
+	Usage:   "send a JSON breakdown of discovery, dial, ttfb, transfer and cache timing in an X-Timing-Breakdown trailer to requests with ?timing=true bearing the admin token; requires --admin-token",
+	EnvVars: []string{"LASSIE_TIMING_BREAKDOWN"},
+}
+
+var FlagEmitXCache = &cli.BoolFlag{
+	Name:    "emit-x-cache",
+	Usage:   "add an X-Cache header of HIT, MISS or BYPASS to responses",
+	EnvVars: []string{"LASSIE_EMIT_X_CACHE"},
+}
+
+var FlagCompress = &cli.BoolFlag{
+	Name:    "compress",
+	Usage:   "compress responses with brotli or gzip for clients that accept them in Accept-Encoding; the cache keeps storing them uncompressed",
+	EnvVars: []string{"LASSIE_COMPRESS"},
+}
+
+var FlagEmitServedBy = &cli.BoolFlag{
+	Name:    "emit-served-by",
+	Usage:   "add an X-Served-By header with the --event-recorder-instance-id if set, or else the hostname, to responses",
+	EnvVars: []string{"LASSIE_EMIT_SERVED_BY"},
+}
+
+var FlagHTTP10Mode = &cli.StringFlag{
+	Name:    "http10-mode",
+	Usage:   "how to serve HTTP/1.0 clients, which can't receive chunked responses: passthrough to stream until the connection closes, buffer the response to send a Content-Length, or reject with a 505",
+	Value:   "passthrough",
+	EnvVars: []string{"LASSIE_HTTP10_MODE"},
+}
+
+var FlagHTTP10MaxBuffer = &cli.StringFlag{
+	Name:    "http10-max-buffer",
+	Usage:   "the largest response buffered for an HTTP/1.0 client with --http10-mode=buffer, e.g. 64MiB; larger responses are rejected with a 505",
+	Value:   "64MiB",
+	EnvVars: []string{"LASSIE_HTTP10_MAX_BUFFER"},
+}
+
+var FlagMetricsPath = &cli.StringFlag{
+	Name:    "metrics-path",
+	Usage:   "the path Prometheus metrics are served on, set to an empty string to disable",
+	Value:   "/metrics",
+	EnvVars: []string{"LASSIE_METRICS_PATH"},
+}
+
+var FlagEnablePprof = &cli.BoolFlag{
+	Name:    "enable-pprof",
+	Usage:   "serve pprof profiles under /debug/pprof/, requires --admin-token",
+	EnvVars: []string{"LASSIE_ENABLE_PPROF"},
+}
+
+var FlagAccessLogFormat = &cli.StringFlag{
+	Name:        "access-log-format",
+	Usage:       "log every request at INFO level, as logfmt or json",
+	DefaultText: "no access log",
+	EnvVars:     []string{"LASSIE_ACCESS_LOG_FORMAT"},
+}
+
+// injectLatencyConfirmation must be set in the environment, to
+// injectLatencyConfirmationValue, for --inject-latency to take effect. It
+// keeps a stray flag or environment variable from slowing production down.
+const (
+	injectLatencyConfirmation      = "LASSIE_INJECT_LATENCY_CONFIRM"
+	injectLatencyConfirmationValue = "i-am-testing"
+)
+
+var injectFirstByteLatency, injectPerBlockLatency time.Duration
+var FlagInjectLatency = &cli.StringFlag{
+	Name:   "inject-latency",
+	Usage:  "for testing only, delay retrievals, but not cache hits, by phase, such as first-byte=500ms,per-block=10ms; requires " + injectLatencyConfirmation + "=" + injectLatencyConfirmationValue,
+	Hidden: true,
+	Action: func(cctx *cli.Context, v string) error {
+		if v == "" {
+			return nil
+		}
+		if os.Getenv(injectLatencyConfirmation) != injectLatencyConfirmationValue {
+			return fmt.Errorf("--inject-latency requires %s=%s to be set", injectLatencyConfirmation, injectLatencyConfirmationValue)
+		}
+
+		for _, phase := range strings.Split(v, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(phase), "=")
+			if !ok {
+				return fmt.Errorf("invalid injected latency %q, must be phase=duration", phase)
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid injected latency for %s: %w", name, err)
+			}
+			switch name {
+			case "first-byte":
+				injectFirstByteLatency = d
+			case "per-block":
+				injectPerBlockLatency = d
+			default:
+				return fmt.Errorf("unknown latency injection phase %q, must be one of first-byte or per-block", name)
+			}
+		}
+		return nil
+	},
+}
+
 var FlagIPNIEndpoint = &cli.StringFlag{
 	Name:        "ipni-endpoint",
 	Aliases:     []string{"ipni"},
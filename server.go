@@ -2,15 +2,19 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/url"
+	"os"
 
 	"github.com/filecoin-saturn/cassiopeia/httpserver"
 
+	"github.com/dustin/go-humanize"
 	"github.com/filecoin-project/lassie/pkg/aggregateeventrecorder"
 	"github.com/filecoin-project/lassie/pkg/indexerlookup"
 	"github.com/filecoin-project/lassie/pkg/lassie"
-	"github.com/filecoin-project/lassie/pkg/net/host"
 	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/config"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
@@ -44,22 +48,136 @@ func serveAction(cctx *cli.Context) error {
 
 	// http server config
 	address := cctx.String("address")
+	listenNetwork := cctx.String("listen-network")
+	tlsCertFile := cctx.String("tls-cert")
+	tlsKeyFile := cctx.String("tls-key")
 	port := cctx.Uint("port")
 	tempDir := cctx.String("tempdir")
+	cacheDir := cctx.String("cache-dir")
 	maxBlocks := cctx.Uint64("maxblocks")
+	flushInterval := cctx.Duration("flush-interval")
+	shutdownTimeout := cctx.Duration("shutdown-timeout")
+	maxConnsPerIP := cctx.Uint("max-conns-per-ip")
+	proxyProtocol := cctx.Bool("proxy-protocol")
+	proxyProtocolTrusted := cctx.StringSlice("proxy-protocol-trusted")
+	allowIPs := cctx.StringSlice("allow-ips")
+	denyIPs := cctx.StringSlice("deny-ips")
+	trustedProxies := cctx.StringSlice("trusted-proxies")
+	maxRequestMetadataBytes := cctx.Uint64("max-request-metadata-bytes")
+	maxConcurrentRequests := cctx.Uint("max-concurrent-requests")
+	maxConcurrentAll := cctx.Uint("max-concurrent-all")
+	maxConcurrentEntity := cctx.Uint("max-concurrent-entity")
+	maxConcurrentBlock := cctx.Uint("max-concurrent-block")
 	accessToken := cctx.String("access-token")
+	adminToken := cctx.String("admin-token")
+	disableCache := cctx.Bool("disable-cache")
+	cacheBackend := cctx.String("cache-backend")
+	redisAddr := cctx.String("redis-addr")
+	redisPassword := cctx.String("redis-password")
+	cacheOpenRetries := cctx.Uint("cache-open-retries")
+	cacheControl := cctx.String("cache-control")
+	var cacheMinFreeDisk uint64
+	if v := cctx.String("cache-min-free-disk"); v != "" {
+		cacheMinFreeDisk, err = humanize.ParseBytes(v)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("invalid cache-min-free-disk %q: %w", v, err), 1)
+		}
+	}
+	cacheGCInterval := cctx.Duration("cache-gc-interval")
+	staleWhileRevalidate := cctx.Duration("stale-while-revalidate")
+	cacheAdmission := cctx.String("cache-admission")
+	normalizePaths := cctx.Bool("normalize-paths")
+	providerLatencyWindow := cctx.Duration("provider-latency-window")
+	legacyParams := cctx.Bool("legacy-params")
+	duplicates := cctx.String("duplicates")
+	serverTiming := cctx.String("server-timing")
+	timingBreakdown := cctx.Bool("timing-breakdown")
+	emitXCache := cctx.Bool("emit-x-cache")
+	compress := cctx.Bool("compress")
+	metricsPath := cctx.String("metrics-path")
+	enablePprof := cctx.Bool("enable-pprof")
+	accessLogFormat := cctx.String("access-log-format")
+	if accessLogFormat != "" && os.Getenv("GOLOG_LOG_LEVEL") == "" {
+		// the access log is written at INFO level, which is otherwise hidden
+		_ = log.SetLogLevel("cassiopeia/httpserver", "INFO")
+	}
+	http10Mode := cctx.String("http10-mode")
+	http10MaxBuffer, err := humanize.ParseBytes(cctx.String("http10-max-buffer"))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("invalid http10-max-buffer %q: %w", cctx.String("http10-max-buffer"), err), 1)
+	}
+	if http10MaxBuffer == 0 || http10MaxBuffer > math.MaxInt {
+		return cli.Exit(fmt.Errorf("invalid http10-max-buffer %q, must be a positive size", cctx.String("http10-max-buffer")), 1)
+	}
 	httpServerCfg := httpserver.HttpServerConfig{
-		Address:             address,
-		Port:                port,
-		TempDir:             tempDir,
-		MaxBlocksPerRequest: maxBlocks,
-		AccessToken:         accessToken,
+		Address:                 address,
+		ListenNetwork:           listenNetwork,
+		TLSCertFile:             tlsCertFile,
+		TLSKeyFile:              tlsKeyFile,
+		MaxConnsPerIP:           maxConnsPerIP,
+		ProxyProtocol:           proxyProtocol,
+		ProxyProtocolTrusted:    proxyProtocolTrusted,
+		AllowIPs:                allowIPs,
+		DenyIPs:                 denyIPs,
+		TrustedProxies:          trustedProxies,
+		MaxRequestMetadataBytes: maxRequestMetadataBytes,
+		MaxConcurrentRequests:   maxConcurrentRequests,
+		MaxConcurrentAll:        maxConcurrentAll,
+		MaxConcurrentEntity:     maxConcurrentEntity,
+		MaxConcurrentBlock:      maxConcurrentBlock,
+		Port:                    port,
+		TempDir:                 tempDir,
+		CacheDir:                cacheDir,
+		MaxBlocksPerRequest:     maxBlocks,
+		Protocols:               protocols,
+		FlushInterval:           flushInterval,
+		ShutdownTimeout:         shutdownTimeout,
+		AccessToken:             accessToken,
+		AdminToken:              adminToken,
+		DisableCache:            disableCache,
+		CacheBackend:            cacheBackend,
+		RedisAddr:               redisAddr,
+		RedisPassword:           redisPassword,
+		CacheOpenRetries:        cacheOpenRetries,
+		CacheControl:            cacheControl,
+		CacheMinFreeDisk:        cacheMinFreeDisk,
+		CacheGCInterval:         cacheGCInterval,
+		StaleWhileRevalidate:    staleWhileRevalidate,
+		CacheAdmission:          cacheAdmission,
+		NormalizePaths:          normalizePaths,
+		ProviderLatencyWindow:   providerLatencyWindow,
+		LegacyParams:            legacyParams,
+		AllowedAccept:           allowedAccept,
+		Duplicates:              duplicates,
+		ServerTiming:            serverTiming,
+		TimingBreakdown:         timingBreakdown,
+		EmitXCache:              emitXCache,
+		Compress:                compress,
+		MetricsPath:             metricsPath,
+		EnablePprof:             enablePprof,
+		AccessLogFormat:         accessLogFormat,
+		InjectFirstByteLatency:  injectFirstByteLatency,
+		InjectPerBlockLatency:   injectPerBlockLatency,
+		HTTP10Mode:              http10Mode,
+		HTTP10MaxBufferBytes:    int(http10MaxBuffer),
 	}
 
 	// event recorder config
 	eventRecorderURL := cctx.String("event-recorder-url")
 	authToken := cctx.String("event-recorder-auth")
 	instanceID := cctx.String("event-recorder-instance-id")
+	if cctx.Bool("emit-served-by") {
+		// a derived instance ID would mean nothing to someone reading the
+		// header, so the hostname is used unless an ID was configured
+		httpServerCfg.ServedBy = instanceID
+		if httpServerCfg.ServedBy == "" {
+			httpServerCfg.ServedBy, _ = os.Hostname()
+		}
+	}
+	instanceID, err = eventRecorderInstanceID(instanceID, cctx.String("event-recorder-instance-seed"))
+	if err != nil {
+		logger.Warnw("failed to derive event recorder instance ID, a random one will be used", "err", err)
+	}
 	eventRecorderCfg := &aggregateeventrecorder.EventRecorderConfig{
 		InstanceID:            instanceID,
 		EndpointURL:           eventRecorderURL,
@@ -73,8 +191,17 @@ func serveAction(cctx *cli.Context) error {
 
 	// create and subscribe an event recorder API if an endpoint URL is set
 	if eventRecorderCfg.EndpointURL != "" {
+		logger.Infow("sending retrieval events to event recorder", "url", eventRecorderCfg.EndpointURL, "instance_id", eventRecorderCfg.InstanceID)
 		eventRecorder := aggregateeventrecorder.NewAggregateEventRecorder(cctx.Context, *eventRecorderCfg)
-		lassie.RegisterSubscriber(eventRecorder.RetrievalEventSubscriber())
+		bufferSize := cctx.Int("event-recorder-buffer-size")
+		dropPolicy := cctx.String("event-recorder-drop-policy")
+		subscriber, err := newBufferedSubscriber(eventRecorder.RetrievalEventSubscriber(), bufferSize, dropPolicy)
+		if err != nil {
+			return cli.Exit(err, 1)
+		}
+		go subscriber.Run(cctx.Context)
+		httpServerCfg.MetricsCollectors = append(httpServerCfg.MetricsCollectors, subscriber.Collectors()...)
+		lassie.RegisterSubscriber(subscriber.Subscriber())
 	}
 
 	httpServer, err := httpserver.NewHttpServer(cctx.Context, lassie, httpServerCfg)
@@ -107,6 +234,29 @@ func serveAction(cctx *cli.Context) error {
 	return nil
 }
 
+// eventRecorderInstanceID returns the configured instance ID, or else one
+// derived from the seed
+func eventRecorderInstanceID(id, seed string) (string, error) {
+	if id != "" {
+		return id, nil
+	}
+	return deriveInstanceID(seed)
+}
+
+// deriveInstanceID returns a stable UUID derived from the given seed, or from
+// the hostname if the seed is empty, so that event recorder metrics from the
+// same node correlate across restarts.
+func deriveInstanceID(seed string) (string, error) {
+	if seed == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("cannot read hostname: %w", err)
+		}
+		seed = hostname
+	}
+	return uuid.NewSHA1(uuid.NameSpaceDNS, []byte(seed)).String(), nil
+}
+
 func buildLassieConfigFromCLIContext(cctx *cli.Context, lassieOpts []lassie.LassieOption, libp2pOpts []config.Option) (*lassie.LassieConfig, error) {
 	providerTimeout := cctx.Duration("provider-timeout")
 	globalTimeout := cctx.Duration("global-timeout")
@@ -122,7 +272,7 @@ func buildLassieConfigFromCLIContext(cctx *cli.Context, lassieOpts []lassie.Lass
 		lassieOpts = append(lassieOpts, lassie.WithProtocols(protocols))
 	}
 
-	host, err := host.InitHost(cctx.Context, libp2pOpts)
+	host, err := initHost(cctx.Context, libp2pOpts, cctx.Uint("host-init-retries"))
 	if err != nil {
 		return nil, err
 	}
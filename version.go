@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+
+	"github.com/urfave/cli/v2"
+)
+
+// version is the cassiopeia release, set at build time with
+// -ldflags "-X main.version=v1.2.3". Builds without it fall back to the
+// module version recorded by the go tool.
+var version string
+
+const lassieModule = "github.com/filecoin-project/lassie"
+
+// goBuildInfo reads the build information embedded by the go tool, it is a
+// variable so tests can simulate different builds
+var goBuildInfo = debug.ReadBuildInfo
+
+// buildInfo describes the running binary
+type buildInfo struct {
+	version       string
+	commit        string
+	modified      bool
+	goVersion     string
+	lassieVersion string
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{version: version, commit: "unknown", lassieVersion: "unknown"}
+	bi, ok := goBuildInfo()
+	if !ok {
+		if info.version == "" {
+			info.version = "unknown"
+		}
+		return info
+	}
+
+	info.goVersion = bi.GoVersion
+	if info.version == "" {
+		info.version = bi.Main.Version
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.commit = setting.Value
+		case "vcs.modified":
+			info.modified = setting.Value == "true"
+		}
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path != lassieModule {
+			continue
+		}
+		info.lassieVersion = dep.Version
+		if dep.Replace != nil {
+			info.lassieVersion = dep.Replace.Path + " " + dep.Replace.Version
+		}
+	}
+	return info
+}
+
+func (info buildInfo) print(w io.Writer) {
+	commit := info.commit
+	if info.modified {
+		commit += " (modified)"
+	}
+	fmt.Fprintf(w, "cassiopeia %s\n", info.version)
+	fmt.Fprintf(w, "commit:  %s\n", commit)
+	fmt.Fprintf(w, "go:      %s\n", info.goVersion)
+	fmt.Fprintf(w, "lassie:  %s\n", info.lassieVersion)
+}
+
+var versionCommand = &cli.Command{
+	Name:  "version",
+	Usage: "print the version of cassiopeia, the commit it was built from, and its Go and lassie versions",
+	Action: func(cctx *cli.Context) error {
+		readBuildInfo().print(cctx.App.Writer)
+		return nil
+	},
+}
+
+func init() {
+	// -v is taken by --verbose
+	cli.VersionFlag = &cli.BoolFlag{
+		Name:  "version",
+		Usage: "print the version and exit",
+	}
+	cli.VersionPrinter = func(cctx *cli.Context) {
+		readBuildInfo().print(cctx.App.Writer)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"runtime/debug"
+	"testing"
+)
+
+func TestReadBuildInfo(t *testing.T) {
+	lassieDep := &debug.Module{Path: lassieModule, Version: "v0.12.0"}
+	tests := []struct {
+		name    string
+		version string
+		// bi is the go tool's build info, none if nil
+		bi   *debug.BuildInfo
+		want buildInfo
+	}{
+		{
+			name: "no build info",
+			want: buildInfo{version: "unknown", commit: "unknown", lassieVersion: "unknown"},
+		},
+		{
+			name:    "no build info with a release version",
+			version: "v1.2.3",
+			want:    buildInfo{version: "v1.2.3", commit: "unknown", lassieVersion: "unknown"},
+		},
+		{
+			name: "module version",
+			bi:   &debug.BuildInfo{GoVersion: "go1.20", Main: debug.Module{Version: "v1.0.0"}, Deps: []*debug.Module{lassieDep}},
+			want: buildInfo{version: "v1.0.0", commit: "unknown", goVersion: "go1.20", lassieVersion: "v0.12.0"},
+		},
+		{
+			name:    "release version wins",
+			version: "v1.2.3",
+			bi:      &debug.BuildInfo{GoVersion: "go1.20", Main: debug.Module{Version: "v1.0.0"}, Deps: []*debug.Module{lassieDep}},
+			want:    buildInfo{version: "v1.2.3", commit: "unknown", goVersion: "go1.20", lassieVersion: "v0.12.0"},
+		},
+		{
+			name: "vcs settings",
+			bi: &debug.BuildInfo{GoVersion: "go1.20", Main: debug.Module{Version: "(devel)"}, Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "abc123"},
+				{Key: "vcs.modified", Value: "true"},
+			}},
+			want: buildInfo{version: "(devel)", commit: "abc123", modified: true, goVersion: "go1.20", lassieVersion: "unknown"},
+		},
+		{
+			name: "replaced lassie",
+			bi: &debug.BuildInfo{GoVersion: "go1.20", Main: debug.Module{Version: "v1.0.0"}, Deps: []*debug.Module{
+				{Path: lassieModule, Version: "v0.12.0", Replace: &debug.Module{Path: "github.com/example/lassie", Version: "v0.12.1"}},
+			}},
+			want: buildInfo{version: "v1.0.0", commit: "unknown", goVersion: "go1.20", lassieVersion: "github.com/example/lassie v0.12.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreVersion, restoreGoBuildInfo := version, goBuildInfo
+			t.Cleanup(func() { version, goBuildInfo = restoreVersion, restoreGoBuildInfo })
+			version = tt.version
+			goBuildInfo = func() (*debug.BuildInfo, bool) { return tt.bi, tt.bi != nil }
+
+			if got := readBuildInfo(); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildInfoPrint(t *testing.T) {
+	tests := []struct {
+		name string
+		info buildInfo
+		want string
+	}{
+		{
+			name: "clean",
+			info: buildInfo{version: "v1.2.3", commit: "abc123", goVersion: "go1.20", lassieVersion: "v0.12.0"},
+			want: "cassiopeia v1.2.3\ncommit:  abc123\ngo:      go1.20\nlassie:  v0.12.0\n",
+		},
+		{
+			name: "modified",
+			info: buildInfo{version: "(devel)", commit: "abc123", modified: true, goVersion: "go1.20", lassieVersion: "v0.12.0"},
+			want: "cassiopeia (devel)\ncommit:  abc123 (modified)\ngo:      go1.20\nlassie:  v0.12.0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.info.print(&buf)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}